@@ -0,0 +1,98 @@
+package ejson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Audit outcomes. AuditDenied is only recorded by the server package, when a
+// client isn't allowed to decrypt a document.
+const (
+	AuditDecrypted = "decrypted"
+	AuditDenied    = "denied"
+	AuditFailed    = "failed"
+)
+
+// AuditEvent records an attempt to decrypt a document. It never includes
+// decrypted values.
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+	// User is the name of the user running the process, and Host the name
+	// of the machine.
+	User string `json:"user"`
+	Host string `json:"host"`
+	// Client lists the names of the client a server decrypted the document
+	// for (see server.ClientNames), if any.
+	Client []string `json:"client,omitempty"`
+	// File is the absolute path of the document, if it was read from a file.
+	File string `json:"file,omitempty"`
+	// PublicKey is the hex-encoded public key of the document, if it has a
+	// valid one.
+	PublicKey string `json:"public_key,omitempty"`
+	// Paths are the paths of the values that were decrypted, sorted.
+	Paths   []string `json:"paths"`
+	Outcome string   `json:"outcome"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// AuditFunc is called with an AuditEvent after each attempt to decrypt a
+// document (see DecryptOptions.Audit). If it returns an error, decryption
+// fails with that error, and nothing is written.
+type AuditFunc func(AuditEvent) error
+
+// JSONAuditLog returns an AuditFunc that writes each event to w as a JSON
+// object on a line of its own. It may be called concurrently.
+func JSONAuditLog(w io.Writer) AuditFunc {
+	var mu sync.Mutex
+	return func(event AuditEvent) error {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_, err = w.Write(append(line, '\n'))
+		return err
+	}
+}
+
+// NewAuditEvent starts an AuditEvent for the current user and host, about the
+// document read from file, which may be empty.
+func NewAuditEvent(file string) AuditEvent {
+	event := AuditEvent{Time: time.Now().UTC(), File: file, Paths: []string{}}
+	if abs, err := filepath.Abs(file); file != "" && err == nil {
+		event.File = abs
+	}
+	if u, err := user.Current(); err == nil {
+		event.User = u.Username
+	} else {
+		event.User = "uid:" + strconv.Itoa(os.Getuid())
+	}
+	event.Host, _ = os.Hostname()
+	return event
+}
+
+// recordAudit sets the outcome of event from err, the result of the attempt
+// to decrypt, and records it with audit. It returns err, or, if the event
+// couldn't be recorded, an error saying so.
+func recordAudit(audit AuditFunc, event AuditEvent, err error) error {
+	sort.Strings(event.Paths)
+	event.Outcome = AuditDecrypted
+	if err != nil {
+		event.Outcome = AuditFailed
+		event.Error = err.Error()
+	}
+	if auditErr := audit(event); auditErr != nil && err == nil {
+		// Nothing is decrypted without a record of it.
+		err = fmt.Errorf("couldn't write audit log: %w", auditErr)
+	}
+	return err
+}
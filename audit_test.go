@@ -0,0 +1,97 @@
+package ejson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAudit(t *testing.T) {
+	Convey("Decrypting with an audit hook", t, func() {
+		var encrypted bytes.Buffer
+		_, err := Encrypt(strings.NewReader(`{"_public_key": "`+validPubKey+`", "b": "hunter2", "a": ["x"], "n": 1}`), &encrypted)
+		So(err, ShouldBeNil)
+		filePath := filepath.Join(t.TempDir(), "secrets.ejson")
+		So(os.WriteFile(filePath, encrypted.Bytes(), 0o600), ShouldBeNil)
+
+		var events []AuditEvent
+		opts := DecryptOptions{Audit: func(event AuditEvent) error {
+			events = append(events, event)
+			return nil
+		}}
+
+		Convey("should record which values were decrypted", func() {
+			_, err := DecryptFileWithOptions(filePath, "", validPrivKey, opts)
+			So(err, ShouldBeNil)
+			So(events, ShouldHaveLength, 1)
+			So(events[0].File, ShouldEqual, filePath)
+			So(events[0].PublicKey, ShouldEqual, validPubKey)
+			So(events[0].Paths, ShouldResemble, []string{"a[0]", "b"})
+			So(events[0].Outcome, ShouldEqual, AuditDecrypted)
+			So(events[0].User, ShouldNotBeEmpty)
+		})
+
+		Convey("should record failures", func() {
+			_, err := DecryptFileWithOptions(filePath, t.TempDir(), "", opts)
+			So(errors.Is(err, ErrKeyNotFound), ShouldBeTrue)
+			So(events, ShouldHaveLength, 1)
+			So(events[0].Outcome, ShouldEqual, AuditFailed)
+			So(events[0].Paths, ShouldBeEmpty)
+			So(events[0].Error, ShouldNotBeEmpty)
+		})
+
+		Convey("should fail without output if the event can't be recorded", func() {
+			opts.Audit = func(AuditEvent) error { return errors.New("disk full") }
+			var out bytes.Buffer
+			err := DecryptWithOptions(bytes.NewReader(encrypted.Bytes()), &out, "", validPrivKey, opts)
+			So(err.Error(), ShouldContainSubstring, "disk full")
+			So(out.Len(), ShouldEqual, 0)
+		})
+
+		Convey("should record values decrypted to describe, compare or merge documents", func() {
+			_, err := DescribeFile(filePath, DescribeOptions{HashKey: []byte("k"), UserSuppliedPrivateKey: validPrivKey, Audit: opts.Audit})
+			So(err, ShouldBeNil)
+			So(events, ShouldHaveLength, 1)
+			So(events[0].File, ShouldEqual, filePath)
+			So(events[0].Paths, ShouldResemble, []string{"a[0]", "b"})
+
+			var other bytes.Buffer
+			_, err = Encrypt(strings.NewReader(`{"_public_key": "`+validPubKey+`", "b": "hunter3", "a": ["x"], "n": 1}`), &other)
+			So(err, ShouldBeNil)
+			diffOpts := DiffOptions{UserSuppliedPrivateKey: validPrivKey, Audit: opts.Audit, Files: []string{"old", "new"}}
+			_, err = Diff(bytes.NewReader(encrypted.Bytes()), bytes.NewReader(other.Bytes()), diffOpts)
+			So(err, ShouldBeNil)
+			So(events, ShouldHaveLength, 3)
+			So(events[1].File, ShouldEqual, "old")
+			So(events[1].Paths, ShouldResemble, []string{"a[0]", "b"})
+			So(events[2].File, ShouldEqual, "new")
+
+			_, _, err = MergeDocuments(bytes.NewReader(encrypted.Bytes()), bytes.NewReader(other.Bytes()), bytes.NewReader(encrypted.Bytes()), diffOpts)
+			So(err, ShouldBeNil)
+			So(events, ShouldHaveLength, 6)
+
+			diffOpts.Audit = func(AuditEvent) error { return errors.New("disk full") }
+			changes, err := Diff(bytes.NewReader(encrypted.Bytes()), bytes.NewReader(other.Bytes()), diffOpts)
+			So(err.Error(), ShouldContainSubstring, "disk full")
+			So(changes, ShouldBeNil)
+		})
+
+		Convey("should write JSON lines without plaintext", func() {
+			var log bytes.Buffer
+			opts.Audit = JSONAuditLog(&log)
+			_, err := DecryptFileWithOptions(filePath, "", validPrivKey, opts)
+			So(err, ShouldBeNil)
+			So(log.String(), ShouldNotContainSubstring, "hunter2")
+			So(strings.Count(log.String(), "\n"), ShouldEqual, 1)
+			var event AuditEvent
+			So(json.Unmarshal(log.Bytes(), &event), ShouldBeNil)
+			So(event.Paths, ShouldResemble, []string{"a[0]", "b"})
+		})
+	})
+}
@@ -1,67 +1,480 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"runtime"
+	"strconv"
+	"sync"
 
 	"github.com/Shopify/ejson"
+	"github.com/Shopify/ejson/internal/atomicfile"
+	"golang.org/x/crypto/ssh"
 )
 
-func encryptAction(args []string) error {
+func encryptAction(args []string, recursive bool, globs []string, opts ejson.EncryptOptions) error {
 	if len(args) < 1 {
 		return fmt.Errorf("at least one file path must be given")
 	}
-	for _, filePath := range args {
-		n, err := ejson.EncryptFileInPlace(filePath)
+	files, err := expandPaths(args, recursive, globs)
+	if err != nil {
+		return err
+	}
+	return forEachFile(files, func(filePath string) (string, error) {
+		n, err := ejson.EncryptFileInPlaceWithOptions(filePath, opts)
 		if err != nil {
-			return err
+			return "", err
 		}
-		fmt.Printf("Wrote %d bytes to %s.\n", n, filePath)
+		return fmt.Sprintf("Wrote %d bytes to %s.", n, filePath), nil
+	})
+}
+
+func checkAction(args []string, recursive bool, globs []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("at least one file path must be given")
+	}
+	files, err := expandPaths(args, recursive, globs)
+	if err != nil {
+		return err
+	}
+	return forEachFile(files, func(filePath string) (string, error) {
+		if err := ejson.CheckFile(filePath); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s: OK", filePath), nil
+	})
+}
+
+// writeKey stores a private key in the keydir, named by its public key.
+func writeKey(keydir, pub, priv string) error {
+	keyFile := fmt.Sprintf("%s/%s", keydir, pub)
+	return writeFile(keyFile, append([]byte(priv), '\n'), 0o440)
+}
+
+// outputOptions controls how decrypted output is written to a file.
+type outputOptions struct {
+	path           string
+	mode           string
+	force          bool
+	followSymlinks bool
+}
+
+// write writes data to the output file, or to stdout if no path was given.
+func (o outputOptions) write(data []byte) error {
+	if o.path == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	perm, err := parseMode(o.mode)
+	if err != nil {
+		return err
+	}
+	err = atomicfile.WriteFile(o.path, data, atomicfile.Options{
+		Perm:           perm,
+		Overwrite:      o.force,
+		FollowSymlinks: o.followSymlinks,
+	})
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s already exists (use --force to overwrite it)", o.path)
+	}
+	if errors.Is(err, atomicfile.ErrSymlink) {
+		return fmt.Errorf("%s is a symlink (use --follow-symlinks to write through it)", o.path)
+	}
+	return err
+}
+
+// parseMode parses the permissions given to --mode, in octal.
+func parseMode(mode string) (os.FileMode, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0o777 {
+		return 0, fmt.Errorf("invalid mode %q", mode)
+	}
+	return os.FileMode(perm), nil
+}
+
+// formatOptions describes the form in which decryptAction outputs the
+// decrypted document.
+type formatOptions struct {
+	format     string
+	recipients []string
+	// path selects the part of the document output by --format=k8s-secret
+	// and --to-dir.
+	path   string
+	secret ejson.SecretOptions
+	toDir  string
+	dir    ejson.DirOptions
+}
+
+// validate checks that the options given make sense together, so that
+// mistakes are reported before anything is decrypted.
+func (f formatOptions) validate() error {
+	switch {
+	case f.format != "k8s-secret" && f.secret != (ejson.SecretOptions{}):
+		return fmt.Errorf("--name, --namespace, --string-data and --sealed may only be given with --format=k8s-secret")
+	case f.toDir == "" && f.dir != (ejson.DirOptions{}):
+		return fmt.Errorf("--separator and --uppercase may only be given with --to-dir")
+	case f.path != "" && f.format != "k8s-secret" && f.toDir == "":
+		return fmt.Errorf("--path may only be given with --format=k8s-secret or --to-dir")
+	case f.toDir != "" && f.format != "" && f.format != "json":
+		return fmt.Errorf("--to-dir may not be combined with --format=%s", f.format)
+	}
+	switch f.format {
+	case "", "json", "k8s-secret":
+		if len(f.recipients) > 0 {
+			return fmt.Errorf("--recipient may only be given with --format=age")
+		}
+		if f.format == "k8s-secret" && f.secret.Name == "" {
+			return fmt.Errorf("--format=k8s-secret requires --name")
+		}
+	case "age":
+		if len(f.recipients) == 0 {
+			return fmt.Errorf("--format=age requires at least one --recipient")
+		}
+	default:
+		return fmt.Errorf("unknown output format %q", f.format)
 	}
 	return nil
 }
 
-func decryptAction(args []string, keydir, userSuppliedPrivateKey, outFile string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("exactly one file path must be given")
+// apply converts a decrypted document to the requested format.
+func (f formatOptions) apply(decrypted []byte) ([]byte, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	switch f.format {
+	case "age":
+		return ejson.EncryptAge(decrypted, f.recipients)
+	case "k8s-secret":
+		secret := f.secret
+		secret.Path = f.path
+		return ejson.KubernetesSecret(decrypted, secret)
+	default:
+		return decrypted, nil
+	}
+}
+
+// writeDir writes a decrypted document to the --to-dir directory, one file
+// per value, with the given mode. With force, a directory that wasn't written
+// by --to-dir may be replaced.
+func (f formatOptions) writeDir(decrypted []byte, mode string, force bool) error {
+	perm, err := parseMode(mode)
+	if err != nil {
+		return err
+	}
+	dir := f.dir
+	dir.Path = f.path
+	dir.Perm = perm
+	dir.Force = force
+	return ejson.WriteDir(f.toDir, decrypted, dir)
+}
+
+// mergeOptions describes how decryptAction combines several files.
+type mergeOptions struct {
+	merge   bool
+	explain bool
+}
+
+func decryptAction(args []string, keydir, userSuppliedPrivateKey string, flags decryptFlags, merge mergeOptions, format formatOptions, out outputOptions) error {
+	switch {
+	case merge.merge && len(args) < 1:
+		return fmt.Errorf("at least one file path must be given")
+	case !merge.merge && len(args) != 1:
+		return fmt.Errorf("exactly one file path must be given (use --merge to combine several)")
+	case merge.explain && !merge.merge:
+		return fmt.Errorf("--explain may only be given with --merge")
+	case format.secret.Sealed && merge.merge:
+		return fmt.Errorf("--sealed may not be combined with --merge")
+	case format.toDir != "" && (out.path != "" || out.followSymlinks):
+		return fmt.Errorf("-o and --follow-symlinks may not be combined with --to-dir")
 	}
-	decrypted, err := ejson.DecryptFile(args[0], keydir, userSuppliedPrivateKey)
+	if err := format.validate(); err != nil {
+		return err
+	}
+	opts, closeAuditLog, err := flags.options()
 	if err != nil {
 		return err
 	}
+	defer closeAuditLog()
 
-	target := os.Stdout
-	if outFile != "" {
-		target, err = os.Create(outFile)
+	var decrypted []byte
+	if format.secret.Sealed {
+		// A sealed Secret keeps the values encrypted, so the file is used as
+		// it is, once its signature has been checked.
+		if opts.TrustedSigners != nil {
+			if _, err := ejson.VerifySignatureFile(args[0], opts.TrustedSigners); err != nil {
+				return describeError(args[0], err)
+			}
+		}
+		if decrypted, err = os.ReadFile(args[0]); err != nil {
+			return err
+		}
+	} else if merge.merge {
+		var origins []ejson.ValueOrigin
+		decrypted, origins, err = ejson.DecryptMerge(args, keydir, userSuppliedPrivateKey, opts)
 		if err != nil {
 			return err
 		}
-		defer func() { _ = target.Close() }()
+		if merge.explain {
+			for _, origin := range origins {
+				fmt.Printf("%s\t%s\n", origin.Path, origin.File)
+			}
+			return nil
+		}
+	} else if decrypted, err = ejson.DecryptFileWithOptions(args[0], keydir, userSuppliedPrivateKey, opts); err != nil {
+		return describeError(args[0], err)
 	}
 
-	_, err = target.Write(decrypted)
-	return err
+	if format.toDir != "" {
+		return format.writeDir(decrypted, out.mode, out.force)
+	}
+	formatted, err := format.apply(decrypted)
+	if err != nil {
+		return err
+	}
+	return out.write(formatted)
+}
+
+func renderAction(args []string, dataPath, keydir, userSuppliedPrivateKey string, flags decryptFlags, out outputOptions) error {
+	if len(args) != 1 {
+		return fmt.Errorf("exactly one template must be given")
+	}
+	if dataPath == "" {
+		return fmt.Errorf("--data must be given")
+	}
+	opts, closeAuditLog, err := flags.options()
+	if err != nil {
+		return err
+	}
+	defer closeAuditLog()
+	rendered, err := ejson.RenderFile(args[0], dataPath, keydir, userSuppliedPrivateKey, opts)
+	if err != nil {
+		return describeError(dataPath, err)
+	}
+	return out.write(rendered)
 }
 
-func keygenAction(_ []string, keydir string, wFlag bool) error {
-	pub, priv, err := ejson.GenerateKeypair()
+func describeAction(args []string, keydir, userSuppliedPrivateKey, hashKey, auditLog string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("exactly one file path must be given")
+	}
+	opts := ejson.DescribeOptions{
+		Keydir:                 keydir,
+		UserSuppliedPrivateKey: userSuppliedPrivateKey,
+	}
+	if hashKey != "" {
+		opts.HashKey = []byte(hashKey)
+	} else if userSuppliedPrivateKey != "" {
+		return fmt.Errorf("a private key is only needed with --hash-key")
+	}
+	audit, closeAuditLog, err := openAudit(auditLog)
 	if err != nil {
 		return err
 	}
+	defer closeAuditLog()
+	opts.Audit = audit
+	described, err := ejson.DescribeFile(args[0], opts)
+	if err != nil {
+		return describeError(args[0], err)
+	}
+	_, err = os.Stdout.Write(described)
+	return err
+}
 
-	if wFlag {
-		keyFile := fmt.Sprintf("%s/%s", keydir, pub)
-		err := writeFile(keyFile, append([]byte(priv), '\n'), 0o440)
+// keygenOptions describes where keygenAction gets its keypair from, and how
+// it prints it.
+type keygenOptions struct {
+	write      bool
+	fromSSH    string
+	fromAge    string
+	signing    bool
+	passphrase []byte
+	format     string
+}
+
+func keygenAction(_ []string, keydir string, opts keygenOptions) error {
+	if opts.format != "hex" && opts.format != "age" {
+		return fmt.Errorf("unknown key format %q", opts.format)
+	}
+
+	var (
+		pub, priv string
+		err       error
+	)
+	switch {
+	case opts.signing:
+		if opts.write || opts.fromSSH != "" || opts.fromAge != "" || opts.format != "hex" {
+			return fmt.Errorf("--signing may not be combined with other options")
+		}
+		if pub, priv, err = ejson.GenerateSigningKeypair(); err != nil {
+			return err
+		}
+	case opts.fromSSH != "" && opts.fromAge != "":
+		return fmt.Errorf("only one of --from-ssh and --from-age may be given")
+	case opts.fromSSH != "":
+		data, err := os.ReadFile(opts.fromSSH)
 		if err != nil {
 			return err
 		}
-		fmt.Println(pub)
+		if !bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+			if opts.write {
+				return fmt.Errorf("%s is not a private key, so there is nothing to write", opts.fromSSH)
+			}
+			return printSSHPublicKeys(data, opts.format)
+		}
+		pub, priv, err = ejson.KeypairFromSSH(data, opts.passphrase)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return fmt.Errorf("%s is encrypted; pass its passphrase with --passphrase-from-stdin", opts.fromSSH)
+		} else if err != nil {
+			return err
+		}
+	case opts.fromAge != "":
+		data, err := os.ReadFile(opts.fromAge)
+		if err != nil {
+			return err
+		}
+		if pub, priv, err = ejson.KeypairFromAge(data); err != nil {
+			return err
+		}
+	default:
+		if pub, priv, err = ejson.GenerateKeypair(); err != nil {
+			return err
+		}
+	}
+
+	if opts.write {
+		if err := writeKey(keydir, pub, priv); err != nil {
+			return err
+		}
+	}
+	shownPub, shownPriv := pub, priv
+	if opts.format == "age" {
+		if shownPub, shownPriv, err = ejson.AgeKeys(pub, priv); err != nil {
+			return err
+		}
+	}
+	if opts.write {
+		fmt.Println(shownPub)
 	} else {
-		fmt.Printf("Public Key:\n%s\nPrivate Key:\n%s\n", pub, priv)
+		fmt.Printf("Public Key:\n%s\nPrivate Key:\n%s\n", shownPub, shownPriv)
 	}
 	return nil
 }
 
+// printSSHPublicKeys prints the ejson public key, in the given format, for
+// each ed25519 key in data, which may be a .pub file or a whole authorized_keys
+// file. Other types of key are skipped with a warning.
+func printSSHPublicKeys(data []byte, format string) error {
+	found := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		pub, comment, err := ejson.PublicKeyFromSSH(line)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Skipping SSH key:", err)
+			continue
+		}
+		found++
+		if format == "age" {
+			if pub, _, err = ejson.AgeKeys(pub, ""); err != nil {
+				return err
+			}
+		}
+		if comment != "" {
+			fmt.Printf("%s\t%s\n", pub, comment)
+		} else {
+			fmt.Println(pub)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if found == 0 {
+		return fmt.Errorf("no ed25519 SSH public keys found")
+	}
+	return nil
+}
+
+// batchError summarizes the failures of an action run over several files. It
+// unwraps to the first failure.
+type batchError struct {
+	failed, total int
+	first         error
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("%d of %d files failed", e.failed, e.total)
+}
+
+func (e *batchError) Unwrap() error {
+	return e.first
+}
+
+// forEachFile runs fn on each of files concurrently, printing the summary
+// line it returns for each file, or the reason it failed. A failure doesn't
+// stop the remaining files from being processed.
+func forEachFile(files []string, fn func(filePath string) (string, error)) error {
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		errs  = make([]error, len(files))
+		limit = make(chan struct{}, runtime.NumCPU())
+	)
+	for i, filePath := range files {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func() {
+			defer func() { <-limit; wg.Done() }()
+			summary, err := fn(filePath)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[i] = err
+				reportFailure(filePath, err)
+			} else {
+				fmt.Println(summary)
+			}
+		}()
+	}
+	wg.Wait()
+
+	batchErr := &batchError{total: len(files)}
+	for _, err := range errs {
+		if err != nil {
+			batchErr.failed++
+			if batchErr.first == nil {
+				batchErr.first = err
+			}
+		}
+	}
+	if batchErr.failed == 0 {
+		return nil
+	}
+	return batchErr
+}
+
+// reportFailure prints the problems with a single file to stderr.
+func reportFailure(filePath string, err error) {
+	problems := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		problems = joined.Unwrap()
+	}
+	for _, problem := range problems {
+		if described := describeError(filePath, problem); described != problem {
+			// Already located within the file.
+			fmt.Fprintln(os.Stderr, described)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filePath, problem)
+		}
+	}
+}
+
 // for mocking in tests
 var (
 	writeFile = os.WriteFile
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+
+	"github.com/Shopify/ejson"
+)
+
+// decryptFlags are the flags that change how decryptAction and renderAction
+// decrypt files.
+type decryptFlags struct {
+	trustedKeys string
+	auditLog    string
+}
+
+// options returns the DecryptOptions described by the flags, and a function
+// that closes the audit log, if there is one.
+func (f decryptFlags) options() (ejson.DecryptOptions, func() error, error) {
+	var opts ejson.DecryptOptions
+	trusted, err := loadTrustedKeys(f.trustedKeys)
+	if err != nil {
+		return opts, nil, err
+	}
+	opts.TrustedSigners = trusted
+	audit, closeLog, err := openAudit(f.auditLog)
+	opts.Audit = audit
+	return opts, closeLog, err
+}
+
+// openAudit returns an AuditFunc appending to the audit log at path, and a
+// function that closes it. If path is empty, there is no audit log, and the
+// AuditFunc is nil.
+func openAudit(path string) (ejson.AuditFunc, func() error, error) {
+	if path == "" {
+		return nil, func() error { return nil }, nil
+	}
+	log, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ejson.JSONAuditLog(log), log.Close, nil
+}
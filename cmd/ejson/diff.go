@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Shopify/ejson"
+)
+
+// changeSymbols prefix each line of diffAction's output.
+var changeSymbols = map[ejson.ChangeKind]string{
+	ejson.Added:           "+",
+	ejson.Removed:         "-",
+	ejson.Changed:         "~",
+	ejson.PossiblyChanged: "?",
+}
+
+func diffAction(args []string, keydir, userSuppliedPrivateKey string, showValues bool, auditLog string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("exactly two files must be given")
+	}
+	a, aName, err := readDiffInput(args[0])
+	if err != nil {
+		return err
+	}
+	b, bName, err := readDiffInput(args[1])
+	if err != nil {
+		return err
+	}
+	audit, closeAuditLog, err := openAudit(auditLog)
+	if err != nil {
+		return err
+	}
+	defer closeAuditLog()
+
+	opts := ejson.DiffOptions{
+		Keydir:                 keydir,
+		UserSuppliedPrivateKey: userSuppliedPrivateKey,
+		Values:                 showValues,
+		Audit:                  audit,
+		Files:                  []string{aName, bName},
+	}
+	changes, err := ejson.Diff(bytes.NewReader(a), bytes.NewReader(b), opts)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		line := changeSymbols[change.Kind] + " " + change.Path
+		switch {
+		case showValues && change.Kind == ejson.Added:
+			line += ": " + string(change.New)
+		case showValues && change.Kind == ejson.Removed:
+			line += ": " + string(change.Old)
+		case showValues:
+			line += ": " + string(change.Old) + " -> " + string(change.New)
+		case change.Kind == ejson.PossiblyChanged:
+			line += " (possibly changed)"
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// readDiffInput reads a document to compare: a file, or, if there's no such
+// file, a git object such as HEAD~1:config/secrets.ejson. It also returns the
+// name to give the document in the audit log: the file's absolute path, or
+// the git object as given.
+func readDiffInput(arg string) (data []byte, name string, err error) {
+	data, err = os.ReadFile(arg)
+	if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(arg, ":") {
+		if abs, absErr := filepath.Abs(arg); absErr == nil {
+			arg = abs
+		}
+		return data, arg, err
+	}
+	// --end-of-options keeps an argument like --output=x:y from being taken
+	// as an option.
+	data, gitErr := exec.Command("git", "show", "--end-of-options", arg).Output()
+	var exitErr *exec.ExitError
+	if errors.As(gitErr, &exitErr) {
+		return nil, "", fmt.Errorf("%s is neither a file nor a git object: %s", arg, bytes.TrimSpace(exitErr.Stderr))
+	} else if gitErr != nil {
+		return nil, "", gitErr
+	}
+	return data, arg, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReadDiffInput(t *testing.T) {
+	Convey("readDiffInput", t, func() {
+		Convey("doesn't pass options through to git", func() {
+			out := filepath.Join(t.TempDir(), "out")
+			_, _, err := readDiffInput("--output=" + out + ":x")
+			So(err, ShouldNotBeNil)
+			_, err = os.Stat(out)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("reports why git couldn't be run", func() {
+			t.Setenv("PATH", t.TempDir())
+			_, _, err := readDiffInput("HEAD:missing.ejson")
+			So(err.Error(), ShouldContainSubstring, "executable file not found")
+		})
+	})
+}
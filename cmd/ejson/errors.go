@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Shopify/ejson"
+	"github.com/Shopify/ejson/crypto"
+	"github.com/Shopify/ejson/json"
+)
+
+// Exit codes, so that scripts can tell the various failure modes apart.
+const (
+	exitFailure             = 1  // anything not covered below
+	exitSyntaxError         = 2  // the document is not valid JSON
+	exitPublicKeyError      = 3  // _public_key is missing or invalid
+	exitKeyNotFound         = 4  // no private key for the document in the keydir
+	exitInvalidPrivateKey   = 5  // the private key is not a valid key
+	exitMalformedCiphertext = 6  // an encrypted value is not in a format we understand
+	exitDecryptionFailed    = 7  // an encrypted value couldn't be decrypted
+	exitNotEncrypted        = 8  // a value that should be encrypted isn't
+	exitPolicyError         = 9  // _ejson or _encrypt_literals is invalid
+	exitKeyMismatch         = 10 // the private key doesn't match _public_key
+	exitSignatureError      = 11 // the signature is missing, invalid or untrusted
+)
+
+// exitCode maps an error returned by one of the actions to the code the
+// process should exit with.
+func exitCode(err error) int {
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return exitSyntaxError
+	case errors.Is(err, json.ErrPublicKeyMissing), errors.Is(err, json.ErrPublicKeyInvalid):
+		return exitPublicKeyError
+	case errors.Is(err, json.ErrPolicyInvalid), errors.Is(err, json.ErrEncryptLiteralsInvalid):
+		return exitPolicyError
+	case errors.Is(err, ejson.ErrKeyNotFound):
+		return exitKeyNotFound
+	case errors.Is(err, ejson.ErrInvalidPrivateKey), errors.Is(err, crypto.ErrInvalidSigningKey):
+		return exitInvalidPrivateKey
+	case errors.Is(err, ejson.ErrKeyMismatch):
+		return exitKeyMismatch
+	case errors.Is(err, ejson.ErrUnsigned), errors.Is(err, ejson.ErrSignatureInvalid), errors.Is(err, ejson.ErrUntrustedSigner):
+		return exitSignatureError
+	case errors.Is(err, crypto.ErrMalformedCiphertext), errors.Is(err, crypto.ErrUnsupportedSchema):
+		return exitMalformedCiphertext
+	case errors.Is(err, crypto.ErrDecryptionFailed):
+		return exitDecryptionFailed
+	case errors.Is(err, ejson.ErrNotEncrypted):
+		return exitNotEncrypted
+	default:
+		return exitFailure
+	}
+}
+
+// locatedError is an error annotated with the file it occurred in, formatted
+// compiler-style.
+type locatedError struct {
+	msg string
+	err error
+}
+
+func (e *locatedError) Error() string {
+	return e.msg
+}
+
+func (e *locatedError) Unwrap() error {
+	return e.err
+}
+
+// describeError formats syntax errors compiler-style, as file:line:col:
+// message, followed by the redacted context of the offending line. Other
+// errors are returned unchanged.
+func describeError(filePath string, err error) error {
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return err
+	}
+	msg := fmt.Sprintf("%s:%d:%d: %s", filePath, syntaxErr.Line, syntaxErr.Column, syntaxErr.Msg)
+	if syntaxErr.Context != "" {
+		msg += "\n\t" + syntaxErr.Context
+	}
+	return &locatedError{msg: msg, err: err}
+}
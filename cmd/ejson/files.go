@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultGlob selects the files processed in recursive mode when no --glob
+// is given.
+const defaultGlob = "*.ejson"
+
+// expandPaths returns the files named by args. When recursive is set, any
+// directory among them is searched for files whose names match one of globs,
+// skipping anything ignored by a .gitignore file along the way.
+func expandPaths(args []string, recursive bool, globs []string) ([]string, error) {
+	if len(globs) == 0 {
+		globs = []string{defaultGlob}
+	}
+	for _, glob := range globs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	var files []string
+	for _, arg := range args {
+		if !recursive {
+			files = append(files, arg)
+			continue
+		}
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		found, err := findFiles(arg, globs)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, found...)
+	}
+	return files, nil
+}
+
+// findFiles walks the tree rooted at root, returning the files whose names
+// match one of globs and which aren't ignored by a .gitignore file.
+func findFiles(root string, globs []string) ([]string, error) {
+	var (
+		files  []string
+		ignore gitignore
+	)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if d.Name() == ".git" || (rel != "." && ignore.ignores(rel, true)) {
+				return filepath.SkipDir
+			}
+			return ignore.load(p, rel)
+		}
+		if ignore.ignores(rel, false) || !matchesAny(globs, d.Name()) {
+			return nil
+		}
+		files = append(files, p)
+		return nil
+	})
+	return files, err
+}
+
+// findAllFiles walks the tree rooted at root like findFiles, but returns
+// every regular file whose name matches one of globs, or every one at all if
+// no globs are given, whether or not a .gitignore file ignores it.
+func findAllFiles(root string, globs []string) ([]string, error) {
+	for _, glob := range globs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, err
+		}
+	}
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() || (len(globs) > 0 && !matchesAny(globs, d.Name())) {
+			return nil
+		}
+		files = append(files, p)
+		return nil
+	})
+	return files, err
+}
+
+func matchesAny(globs []string, name string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// gitignore holds the rules of the .gitignore files found so far while
+// walking a tree, in the order they apply.
+type gitignore []ignoreRule
+
+type ignoreRule struct {
+	base    string // directory containing the .gitignore, relative to the root
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// load reads the .gitignore file in dir, if there is one. rel is the path of
+// dir relative to the root of the walk.
+func (g *gitignore) load(dir, rel string) error {
+	f, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(rel, scanner.Text()); ok {
+			*g = append(*g, rule)
+		}
+	}
+	return scanner.Err()
+}
+
+// ignores reports whether the file or directory at rel (relative to the root
+// of the walk) is ignored. As in git, the last matching rule wins.
+func (g gitignore) ignores(rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range g {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if rule.base != "." {
+			if !strings.HasPrefix(rel, rule.base+"/") {
+				continue
+			}
+			sub = strings.TrimPrefix(rel, rule.base+"/")
+		}
+		if rule.re.MatchString(sub) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// parseIgnoreRule parses one line of a .gitignore file found in base. It
+// supports the commonly used subset of the format: comments, negation with
+// "!", directory-only patterns ending in "/", patterns anchored by a "/", and
+// the "*", "?", "[...]" and "**" wildcards.
+func parseIgnoreRule(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " ")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	line = strings.TrimPrefix(line, `\`)
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	var expr strings.Builder
+	expr.WriteString(`\A`)
+	if !anchored {
+		expr.WriteString(`(?:.*/)?`)
+	}
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case strings.HasPrefix(line[i:], "**/"):
+			expr.WriteString(`(?:.*/)?`)
+			i += 2
+		case strings.HasPrefix(line[i:], "**"):
+			expr.WriteString(`.*`)
+			i++
+		case c == '*':
+			expr.WriteString(`[^/]*`)
+		case c == '?':
+			expr.WriteString(`[^/]`)
+		case c == '[':
+			end := strings.IndexByte(line[i:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := line[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	// Ignoring a directory ignores everything in it.
+	expr.WriteString(`(?:/.*)?\z`)
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.re = re
+	return rule, true
+}
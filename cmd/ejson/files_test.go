@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGitignore(t *testing.T) {
+	Convey("gitignore rules", t, func() {
+		var g gitignore
+		for _, line := range []string{"# comment", "", "*.bak", "/build", "tmp/", "!keep.bak", "docs/**/*.ejson"} {
+			if rule, ok := parseIgnoreRule(".", line); ok {
+				g = append(g, rule)
+			}
+		}
+		sub, _ := parseIgnoreRule("sub", "local.ejson")
+		g = append(g, sub)
+
+		So(g.ignores("a.bak", false), ShouldBeTrue)
+		So(g.ignores("x/y/a.bak", false), ShouldBeTrue)
+		So(g.ignores("keep.bak", false), ShouldBeFalse)
+		So(g.ignores("build", true), ShouldBeTrue)
+		So(g.ignores("x/build", true), ShouldBeFalse)
+		So(g.ignores("x/tmp", true), ShouldBeTrue)
+		So(g.ignores("tmp", false), ShouldBeFalse)
+		So(g.ignores("docs/a/b/c.ejson", false), ShouldBeTrue)
+		So(g.ignores("docs/c.ejson", false), ShouldBeTrue)
+		So(g.ignores("sub/local.ejson", false), ShouldBeTrue)
+		So(g.ignores("local.ejson", false), ShouldBeFalse)
+	})
+}
+
+func TestExpandPaths(t *testing.T) {
+	Convey("expandPaths", t, func() {
+		root := t.TempDir()
+		for _, f := range []string{"a.ejson", "b.json", "sub/c.ejson", "ignored/d.ejson", "sub/e.ejson"} {
+			So(os.MkdirAll(filepath.Join(root, filepath.Dir(f)), 0o755), ShouldBeNil)
+			So(os.WriteFile(filepath.Join(root, f), []byte("{}"), 0o644), ShouldBeNil)
+		}
+		So(os.WriteFile(filepath.Join(root, ".gitignore"), []byte("ignored/\n"), 0o644), ShouldBeNil)
+		So(os.WriteFile(filepath.Join(root, "sub", ".gitignore"), []byte("e.ejson\n"), 0o644), ShouldBeNil)
+
+		Convey("finds matching files, honouring .gitignore", func() {
+			files, err := expandPaths([]string{root}, true, nil)
+			So(err, ShouldBeNil)
+			So(files, ShouldResemble, []string{filepath.Join(root, "a.ejson"), filepath.Join(root, "sub", "c.ejson")})
+		})
+
+		Convey("uses the given globs", func() {
+			files, err := expandPaths([]string{root}, true, []string{"*.json"})
+			So(err, ShouldBeNil)
+			So(files, ShouldResemble, []string{filepath.Join(root, "b.json")})
+		})
+
+		Convey("passes paths through when not recursive", func() {
+			files, err := expandPaths([]string{root}, false, nil)
+			So(err, ShouldBeNil)
+			So(files, ShouldResemble, []string{root})
+		})
+
+		Convey("findAllFiles finds every file, ignored or not", func() {
+			files, err := findAllFiles(root, nil)
+			So(err, ShouldBeNil)
+			So(len(files), ShouldEqual, 7)
+			So(files, ShouldContain, filepath.Join(root, "ignored", "d.ejson"))
+
+			files, err = findAllFiles(root, []string{"*.json"})
+			So(err, ShouldBeNil)
+			So(files, ShouldResemble, []string{filepath.Join(root, "b.json")})
+		})
+	})
+}
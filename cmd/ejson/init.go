@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Shopify/ejson"
+	"github.com/Shopify/ejson/internal/atomicfile"
+	ejsonjson "github.com/Shopify/ejson/json"
+)
+
+// initOptions describes the document created by initAction.
+type initOptions struct {
+	keygen    bool
+	write     bool
+	publicKey string
+	owner     string
+	timestamp bool
+	template  string
+}
+
+// templates are the skeletons initAction can fill in, beyond the metadata.
+// Each is given the name of the file being created, without its extension.
+var templates = map[string]func(name string) string{
+	"empty": func(string) string { return "" },
+	// The layout expected by ejson2env.
+	"environment": func(string) string {
+		return `  "environment": {}` + "\n"
+	},
+	// The layout expected by krane (formerly kubernetes-deploy).
+	"kubernetes": func(name string) string {
+		return fmt.Sprintf(`  "kubernetes_secrets": {
+    %s: {
+      "_type": "Opaque",
+      "data": {}
+    }
+  }
+`, jsonString(name))
+	},
+}
+
+func initAction(args []string, keydir string, opts initOptions) error {
+	if len(args) != 1 {
+		return fmt.Errorf("exactly one file path must be given")
+	}
+	filePath := args[0]
+
+	template, ok := templates[opts.template]
+	if !ok {
+		return fmt.Errorf("unknown template %q", opts.template)
+	}
+
+	pub := opts.publicKey
+	switch {
+	case opts.keygen && pub != "":
+		return fmt.Errorf("only one of --keygen and --public-key may be given")
+	case opts.write && !opts.keygen:
+		return fmt.Errorf("-w may only be given with --keygen")
+	case pub != "":
+		if _, err := ejsonjson.ParsePublicKey(pub); err != nil {
+			return fmt.Errorf("invalid public key %q", pub)
+		}
+	case !opts.keygen:
+		return fmt.Errorf("one of --keygen and --public-key must be given")
+	}
+
+	var priv string
+	if opts.keygen {
+		var err error
+		if pub, priv, err = ejson.GenerateKeypair(); err != nil {
+			return err
+		}
+	}
+
+	var doc bytes.Buffer
+	fmt.Fprintf(&doc, "{\n  \"_public_key\": %s", jsonString(pub))
+	if opts.owner != "" {
+		fmt.Fprintf(&doc, ",\n  \"_owner\": %s", jsonString(opts.owner))
+	}
+	if opts.timestamp {
+		fmt.Fprintf(&doc, ",\n  \"_created_at\": %s", jsonString(time.Now().UTC().Format(time.RFC3339)))
+	}
+	if body := template(strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))); body != "" {
+		doc.WriteString(",\n" + body)
+	} else {
+		doc.WriteString("\n")
+	}
+	doc.WriteString("}\n")
+
+	// Write the key first: an unused key is harmless, but a document whose key
+	// was lost is useless.
+	if opts.write {
+		if err := writeKey(keydir, pub, priv); err != nil {
+			return err
+		}
+	}
+	err := atomicfile.WriteFile(filePath, doc.Bytes(), atomicfile.Options{Perm: 0o644})
+	if err != nil {
+		return err
+	}
+
+	if priv != "" && !opts.write {
+		fmt.Printf("Private Key:\n%s\n", priv)
+	}
+	fmt.Printf("Wrote %s with public key %s.\n", filePath, pub)
+	return nil
+}
+
+// jsonString returns s as a JSON string literal.
+func jsonString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInitAction(t *testing.T) {
+	pub := "8d8647e2eeb6d2e31228e6df7da3df921ec3b799c3f66a171cd37a1ed3004e7d"
+
+	Convey("initAction", t, func() {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.ejson")
+
+		Convey("writes a skeleton for an existing public key", func() {
+			err := initAction([]string{path}, dir, initOptions{publicKey: pub, owner: "ops", template: "kubernetes"})
+			So(err, ShouldBeNil)
+			data, err := os.ReadFile(path)
+			So(err, ShouldBeNil)
+			var doc map[string]any
+			So(json.Unmarshal(data, &doc), ShouldBeNil)
+			So(doc["_public_key"], ShouldEqual, pub)
+			So(doc["_owner"], ShouldEqual, "ops")
+			So(doc["kubernetes_secrets"], ShouldContainKey, "app")
+		})
+
+		Convey("generates and writes a keypair", func() {
+			err := initAction([]string{path}, dir, initOptions{keygen: true, write: true, template: "empty"})
+			So(err, ShouldBeNil)
+			data, _ := os.ReadFile(path)
+			var doc map[string]any
+			So(json.Unmarshal(data, &doc), ShouldBeNil)
+			_, err = os.Stat(filepath.Join(dir, doc["_public_key"].(string)))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("refuses to clobber an existing file", func() {
+			So(os.WriteFile(path, []byte("{}"), 0o644), ShouldBeNil)
+			err := initAction([]string{path}, dir, initOptions{publicKey: pub, template: "empty"})
+			So(os.IsExist(err), ShouldBeTrue)
+		})
+
+		Convey("requires a key", func() {
+			err := initAction([]string{path}, dir, initOptions{template: "empty"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
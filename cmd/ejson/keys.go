@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/Shopify/ejson"
+	"github.com/Shopify/ejson/internal/atomicfile"
+)
+
+func keysListAction(keydir string) error {
+	keys, err := ejson.ListKeys(keydir)
+	if err != nil {
+		return err
+	}
+	invalid := 0
+	for _, key := range keys {
+		if key.Err != nil {
+			invalid++
+			fmt.Printf("%s\tINVALID: %s\n", key.PublicKey, key.Err)
+		} else {
+			fmt.Printf("%s\tOK\n", key.PublicKey)
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d key files are invalid", invalid, len(keys))
+	}
+	return nil
+}
+
+func keysWhichAction(args []string, keydir string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("at least one file path must be given")
+	}
+	return forEachFile(args, func(filePath string) (string, error) {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		keyFile, err := ejson.WhichKey(file, keydir)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s: %s", filePath, keyFile), nil
+	})
+}
+
+func keysPruneAction(keydir string, unusedBy []string, globs []string, remove bool) error {
+	if len(unusedBy) < 1 {
+		return fmt.Errorf("--unused-by must be given at least once")
+	}
+	// Look everywhere, rather than where encrypt and check would: a key
+	// used by a file they'd skip is still in use.
+	var files []string
+	for _, dir := range unusedBy {
+		found, err := findAllFiles(dir, globs)
+		if err != nil {
+			return err
+		}
+		files = append(files, found...)
+	}
+	unused, err := ejson.UnusedKeys(keydir, files)
+	if err != nil {
+		return err
+	}
+	for _, key := range unused {
+		if !remove {
+			fmt.Println(key.Path)
+			continue
+		}
+		if err := os.Remove(key.Path); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s.\n", key.Path)
+	}
+	return nil
+}
+
+func keysSplitAction(args []string, keydir, userSuppliedPrivateKey string, shares, threshold int, dir string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("exactly one public key must be given")
+	}
+	keyShares, err := ejson.SplitKey(args[0], keydir, userSuppliedPrivateKey, shares, threshold)
+	if err != nil {
+		return err
+	}
+	// Write every share or none, so that a failure can't leave an
+	// incomplete set behind.
+	files := make(map[string][]byte, len(keyShares))
+	paths := make([]string, len(keyShares))
+	for i, share := range keyShares {
+		text, err := share.MarshalText()
+		if err != nil {
+			return err
+		}
+		paths[i] = filepath.Join(dir, fmt.Sprintf("%s.share-%d-of-%d", share.PublicKey, share.Index, share.Shares))
+		files[paths[i]] = text
+	}
+	err = atomicfile.WriteFiles(files, 0o600)
+	var pathErr *fs.PathError
+	if errors.Is(err, fs.ErrExist) && errors.As(err, &pathErr) {
+		return fmt.Errorf("%s already exists", pathErr.Path)
+	} else if err != nil {
+		return err
+	}
+	for i, share := range keyShares {
+		fmt.Printf("Wrote share %d of %d to %s.\n", share.Index, share.Shares, paths[i])
+	}
+	return nil
+}
+
+func keysCombineAction(args []string, keydir string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("at least one share file must be given")
+	}
+	shares := make([]ejson.KeyShare, len(args))
+	for i, path := range args {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := shares[i].UnmarshalText(text); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	pub, priv, err := ejson.CombineKey(shares)
+	if err != nil {
+		return err
+	}
+	if err := writeKey(keydir, pub, priv); err != nil {
+		return err
+	}
+	fmt.Println(pub)
+	return nil
+}
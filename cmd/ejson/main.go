@@ -1,15 +1,105 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"runtime"
 	"strings"
 
+	"github.com/Shopify/ejson"
 	"github.com/urfave/cli"
 )
 
+// recursiveFlags are shared by the commands which operate on many files.
+var recursiveFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "recursive, r",
+		Usage: "process matching files in the given directories and their subdirectories, honouring .gitignore",
+	},
+	cli.StringSliceFlag{
+		Name:  "glob",
+		Usage: "with -r, process files whose names match this pattern (default: " + defaultGlob + "; may be repeated)",
+	},
+}
+
+// outputFlags are shared by the commands which write decrypted output.
+var outputFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "o",
+		Usage: "print output to the provided file, rather than stdout",
+	},
+	cli.StringFlag{
+		Name:  "mode",
+		Value: "0600",
+		Usage: "with -o (or decrypt --to-dir), the permissions of the output files, in octal",
+	},
+	cli.BoolFlag{
+		Name:  "force",
+		Usage: "with -o, overwrite the output file if it already exists; with decrypt --to-dir, replace a directory that ejson didn't write",
+	},
+	cli.BoolFlag{
+		Name:  "follow-symlinks",
+		Usage: "with -o, write to the target if the output file is a symlink",
+	},
+}
+
+// outputOptionsFromContext reads the outputFlags.
+func outputOptionsFromContext(c *cli.Context) outputOptions {
+	return outputOptions{
+		path:           c.String("o"),
+		mode:           c.String("mode"),
+		force:          c.Bool("force"),
+		followSymlinks: c.Bool("follow-symlinks"),
+	}
+}
+
+// decryptFlagsFromContext reads the flags that change how files are
+// decrypted.
+func decryptFlagsFromContext(c *cli.Context) decryptFlags {
+	return decryptFlags{
+		trustedKeys: c.String("trusted-keys"),
+		auditLog:    c.GlobalString("audit-log"),
+	}
+}
+
+// readKeyFromStdin reads the private key from STDIN if --key-from-stdin was
+// given, returning "" otherwise. It exits if STDIN can't be read.
+func readKeyFromStdin(c *cli.Context) string {
+	if !c.Bool("key-from-stdin") {
+		return ""
+	}
+	return strings.TrimSpace(string(readStdin()))
+}
+
+// readPassphraseFromStdin reads a passphrase from STDIN if
+// --passphrase-from-stdin was given, returning nil otherwise. Only the line
+// ending is trimmed, since other whitespace may be part of the passphrase.
+func readPassphraseFromStdin(c *cli.Context) []byte {
+	if !c.Bool("passphrase-from-stdin") {
+		return nil
+	}
+	return bytes.TrimRight(readStdin(), "\r\n")
+}
+
+// readStdin reads all of STDIN, exiting if it can't be read.
+func readStdin() []byte {
+	stdinContent, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read from stdin:", err)
+		os.Exit(1)
+	}
+	return stdinContent
+}
+
+// trustedKeysFlag is shared by the commands which check signatures.
+var trustedKeysFlag = cli.StringFlag{
+	Name:   "trusted-keys",
+	Usage:  "a directory of files listing the public keys of trusted signers; files must be signed by one of them",
+	EnvVar: "EJSON_TRUSTED_KEYS",
+}
+
 func main() {
 	// Encryption is expensive. We'd rather burn cycles on many cores than wait.
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -22,6 +112,11 @@ func main() {
 			Usage:  "Directory containing EJSON keys",
 			EnvVar: "EJSON_KEYDIR",
 		},
+		cli.StringFlag{
+			Name:   "audit-log",
+			Usage:  "append a JSON line recording each decryption (but not the decrypted values) to this file",
+			EnvVar: "EJSON_AUDIT_LOG",
+		},
 	}
 	app.Usage = "manage encrypted secrets using public key encryption"
 	app.Version = VERSION
@@ -32,21 +127,280 @@ func main() {
 			Name:      "encrypt",
 			ShortName: "e",
 			Usage:     "(re-)encrypt one or more EJSON files",
+			Flags: append([]cli.Flag{
+				cli.BoolFlag{
+					Name:   "pad",
+					Usage:  "pad new values to hide their length, as if every file's _ejson policy set \"pad\"",
+					EnvVar: "EJSON_PAD",
+				},
+				cli.BoolFlag{
+					Name:   "compress",
+					Usage:  "compress new values where that makes them smaller, as if every file's _ejson policy set \"compress\"",
+					EnvVar: "EJSON_COMPRESS",
+				},
+			}, recursiveFlags...),
 			Action: func(c *cli.Context) {
-				if err := encryptAction(c.Args()); err != nil {
+				opts := ejson.EncryptOptions{
+					Pad:      c.Bool("pad"),
+					Compress: c.Bool("compress"),
+				}
+				if err := encryptAction(c.Args(), c.Bool("recursive"), c.StringSlice("glob"), opts); err != nil {
 					fmt.Fprintln(os.Stderr, "Encryption failed:", err)
-					os.Exit(1)
+					os.Exit(exitCode(err))
+				}
+			},
+		},
+		{
+			Name:  "check",
+			Usage: "check that one or more EJSON files are valid and fully encrypted",
+			Flags: recursiveFlags,
+			Action: func(c *cli.Context) {
+				if err := checkAction(c.Args(), c.Bool("recursive"), c.StringSlice("glob")); err != nil {
+					fmt.Fprintln(os.Stderr, "Check failed:", err)
+					os.Exit(exitCode(err))
+				}
+			},
+		},
+		{
+			Name:      "sign",
+			Usage:     "sign one or more encrypted EJSON files, so that changes to them can be detected",
+			ArgsUsage: "<file>...",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "key",
+					Usage: "the signing key: a key made by keygen --signing, or an OpenSSH ed25519 private key",
+				},
+				cli.BoolFlag{
+					Name:  "passphrase-from-stdin",
+					Usage: "read the passphrase of an SSH signing key from STDIN",
+				},
+			},
+			Action: func(c *cli.Context) {
+				passphrase := readPassphraseFromStdin(c)
+				if err := signAction(c.Args(), c.String("key"), passphrase); err != nil {
+					fmt.Fprintln(os.Stderr, "Signing failed:", err)
+					os.Exit(exitCode(err))
+				}
+			},
+		},
+		{
+			Name:      "verify-signature",
+			Usage:     "verify the signatures of one or more EJSON files",
+			ArgsUsage: "<file>...",
+			Flags:     []cli.Flag{trustedKeysFlag},
+			Action: func(c *cli.Context) {
+				if err := verifySignatureAction(c.Args(), c.String("trusted-keys")); err != nil {
+					fmt.Fprintln(os.Stderr, "Verification failed:", err)
+					os.Exit(exitCode(err))
 				}
 			},
 		},
 		{
 			Name:      "decrypt",
 			ShortName: "d",
-			Usage:     "decrypt an EJSON file",
+			Usage:     "decrypt an EJSON file, or merge several with --merge",
+			Flags: append([]cli.Flag{
+				cli.BoolFlag{
+					Name:  "key-from-stdin",
+					Usage: "Read the private key from STDIN",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Value: "json",
+					Usage: "the output format: json, age to encrypt the output to --recipient, or k8s-secret for a Kubernetes Secret manifest",
+				},
+				cli.StringSliceFlag{
+					Name:  "recipient",
+					Usage: "with --format=age, an age recipient (or ejson public key) to encrypt to; may be repeated",
+				},
+				cli.StringFlag{
+					Name:  "name",
+					Usage: "with --format=k8s-secret, the name of the Secret",
+				},
+				cli.StringFlag{
+					Name:  "namespace",
+					Usage: "with --format=k8s-secret, the namespace of the Secret",
+				},
+				cli.StringFlag{
+					Name:  "path",
+					Usage: "with --format=k8s-secret or --to-dir, the dot-separated keys of the object to output, instead of the whole document",
+				},
+				cli.BoolFlag{
+					Name:  "string-data",
+					Usage: "with --format=k8s-secret, put the values in stringData instead of base64-encoding them into data",
+				},
+				cli.BoolFlag{
+					Name:  "sealed",
+					Usage: "with --format=k8s-secret, leave the values encrypted, for a decrypter running in the cluster",
+				},
+				cli.StringFlag{
+					Name:  "to-dir",
+					Usage: "write each value to its own file in the given directory, replacing its contents (with mode 0400 unless --mode is given)",
+				},
+				cli.StringFlag{
+					Name:  "separator",
+					Usage: "with --to-dir, the string joining the keys on the path to a value in its file name (default \"_\")",
+				},
+				cli.BoolFlag{
+					Name:  "uppercase",
+					Usage: "with --to-dir, make file names upper case",
+				},
+				trustedKeysFlag,
+				cli.BoolFlag{
+					Name:  "merge",
+					Usage: "decrypt several files and merge them, each on top of those before it",
+				},
+				cli.BoolFlag{
+					Name:  "explain",
+					Usage: "with --merge, print the file each value came from instead of the merged document",
+				},
+			}, outputFlags...),
+			Action: func(c *cli.Context) {
+				userSuppliedPrivateKey := readKeyFromStdin(c)
+				out := outputOptionsFromContext(c)
+				format := formatOptions{
+					format:     c.String("format"),
+					recipients: c.StringSlice("recipient"),
+					path:       c.String("path"),
+					secret: ejson.SecretOptions{
+						Name:       c.String("name"),
+						Namespace:  c.String("namespace"),
+						StringData: c.Bool("string-data"),
+						Sealed:     c.Bool("sealed"),
+					},
+					toDir: c.String("to-dir"),
+					dir: ejson.DirOptions{
+						Separator: c.String("separator"),
+						Uppercase: c.Bool("uppercase"),
+					},
+				}
+				if format.toDir != "" && !c.IsSet("mode") {
+					out.mode = "0400"
+				}
+				merge := mergeOptions{
+					merge:   c.Bool("merge"),
+					explain: c.Bool("explain"),
+				}
+				if err := decryptAction(c.Args(), c.GlobalString("keydir"), userSuppliedPrivateKey, decryptFlagsFromContext(c), merge, format, out); err != nil {
+					fmt.Fprintln(os.Stderr, "Decryption failed:", err)
+					os.Exit(exitCode(err))
+				}
+			},
+		},
+		{
+			Name:      "render",
+			Usage:     "render a text/template with the decrypted values of an EJSON file",
+			ArgsUsage: "<template>",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "data",
+					Usage: "the EJSON file to decrypt and pass to the template",
+				},
+				cli.BoolFlag{
+					Name:  "key-from-stdin",
+					Usage: "Read the private key from STDIN",
+				},
+				trustedKeysFlag,
+			}, outputFlags...),
+			Action: func(c *cli.Context) {
+				userSuppliedPrivateKey := readKeyFromStdin(c)
+				if err := renderAction(c.Args(), c.String("data"), c.GlobalString("keydir"), userSuppliedPrivateKey, decryptFlagsFromContext(c), outputOptionsFromContext(c)); err != nil {
+					fmt.Fprintln(os.Stderr, "Rendering failed:", err)
+					os.Exit(exitCode(err))
+				}
+			},
+		},
+		{
+			Name:  "serve",
+			Usage: "decrypt EJSON documents POSTed over HTTP, on a Unix socket or with TLS client authentication",
 			Flags: []cli.Flag{
 				cli.StringFlag{
-					Name:  "o",
-					Usage: "print output to the provided file, rather than stdout",
+					Name:  "socket",
+					Usage: "listen on a Unix socket at this path",
+				},
+				cli.StringFlag{
+					Name:  "socket-mode",
+					Value: "0600",
+					Usage: "the permissions of the Unix socket, in octal",
+				},
+				cli.StringFlag{
+					Name:  "listen",
+					Usage: "listen for TLS connections on this address, such as :8443",
+				},
+				cli.StringFlag{
+					Name:  "tls-cert",
+					Usage: "with --listen, the server's certificate",
+				},
+				cli.StringFlag{
+					Name:  "tls-key",
+					Usage: "with --listen, the server's private key",
+				},
+				cli.StringFlag{
+					Name:  "client-ca",
+					Usage: "with --listen, the CA certificates that client certificates must be signed by",
+				},
+				cli.StringFlag{
+					Name:  "authorized-clients",
+					Usage: "a JSON file mapping client names to the public keys of the documents they may decrypt (required with --listen)",
+				},
+				cli.StringFlag{
+					Name:  "audit-log",
+					Usage: "append a JSON line for each request to this file (default: the global --audit-log, or stderr)",
+				},
+				trustedKeysFlag,
+			},
+			Action: func(c *cli.Context) {
+				opts := serveOptions{
+					socket:      c.String("socket"),
+					socketMode:  c.String("socket-mode"),
+					listen:      c.String("listen"),
+					tlsCert:     c.String("tls-cert"),
+					tlsKey:      c.String("tls-key"),
+					clientCA:    c.String("client-ca"),
+					policy:      c.String("authorized-clients"),
+					auditLog:    c.String("audit-log"),
+					trustedKeys: c.String("trusted-keys"),
+				}
+				if opts.auditLog == "" {
+					opts.auditLog = c.GlobalString("audit-log")
+				}
+				if err := serveAction(c.GlobalString("keydir"), opts); err != nil {
+					fmt.Fprintln(os.Stderr, "Serving failed:", err)
+					os.Exit(exitCode(err))
+				}
+			},
+		},
+		{
+			Name:      "describe",
+			Usage:     "print an EJSON file with its encrypted values replaced by a description of their ciphertext",
+			ArgsUsage: "<file>",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "hash-key",
+					Usage:  "add a hash of each value, keyed with this secret, so that equal values can be spotted (needs the private key)",
+					EnvVar: "EJSON_HASH_KEY",
+				},
+				cli.BoolFlag{
+					Name:  "key-from-stdin",
+					Usage: "with --hash-key, read the private key from STDIN",
+				},
+			},
+			Action: func(c *cli.Context) {
+				userSuppliedPrivateKey := readKeyFromStdin(c)
+				if err := describeAction(c.Args(), c.GlobalString("keydir"), userSuppliedPrivateKey, c.String("hash-key"), c.GlobalString("audit-log")); err != nil {
+					fmt.Fprintln(os.Stderr, "Description failed:", err)
+					os.Exit(exitCode(err))
+				}
+			},
+		},
+		{
+			Name:      "diff",
+			Usage:     "list the paths added, removed and changed between two EJSON files",
+			ArgsUsage: "<file or revision:path> <file or revision:path>",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "show-values",
+					Usage: "print the old and new values, decrypted where possible",
 				},
 				cli.BoolFlag{
 					Name:  "key-from-stdin",
@@ -54,21 +408,165 @@ func main() {
 				},
 			},
 			Action: func(c *cli.Context) {
-				var userSuppliedPrivateKey string
-				if c.Bool("key-from-stdin") {
-					stdinContent, err := io.ReadAll(os.Stdin)
-					if err != nil {
-						fmt.Fprintln(os.Stderr, "Failed to read from stdin:", err)
-						os.Exit(1)
-					}
-					userSuppliedPrivateKey = strings.TrimSpace(string(stdinContent))
-				}
-				if err := decryptAction(c.Args(), c.GlobalString("keydir"), userSuppliedPrivateKey, c.String("o")); err != nil {
-					fmt.Fprintln(os.Stderr, "Decryption failed:", err)
-					os.Exit(1)
+				userSuppliedPrivateKey := readKeyFromStdin(c)
+				if err := diffAction(c.Args(), c.GlobalString("keydir"), userSuppliedPrivateKey, c.Bool("show-values"), c.GlobalString("audit-log")); err != nil {
+					fmt.Fprintln(os.Stderr, "Diff failed:", err)
+					os.Exit(exitCode(err))
 				}
 			},
 		},
+		{
+			Name:      "merge-driver",
+			Usage:     "merge two branches' changes to an EJSON file, as a git merge driver",
+			ArgsUsage: "<base> <ours> <theirs>",
+			Action: func(c *cli.Context) {
+				if err := mergeDriverAction(c.Args(), c.GlobalString("keydir"), c.GlobalString("audit-log")); err != nil {
+					fmt.Fprintln(os.Stderr, "Merge failed:", err)
+					os.Exit(exitCode(err))
+				}
+			},
+		},
+		{
+			Name:      "init",
+			Usage:     "create a new EJSON file",
+			ArgsUsage: "<file>",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "keygen",
+					Usage: "generate a new keypair for the file",
+				},
+				cli.BoolFlag{
+					Name:  "write, w",
+					Usage: "with --keygen, write the private key into the keydir rather than printing it",
+				},
+				cli.StringFlag{
+					Name:  "public-key",
+					Usage: "use an existing public key for the file",
+				},
+				cli.StringFlag{
+					Name:  "owner",
+					Usage: "record an owner for the file in _owner",
+				},
+				cli.BoolFlag{
+					Name:  "timestamp",
+					Usage: "record the creation time of the file in _created_at",
+				},
+				cli.StringFlag{
+					Name:  "template",
+					Value: "empty",
+					Usage: "the skeleton to start from: empty, environment or kubernetes",
+				},
+			},
+			Action: func(c *cli.Context) {
+				opts := initOptions{
+					keygen:    c.Bool("keygen"),
+					write:     c.Bool("write"),
+					publicKey: c.String("public-key"),
+					owner:     c.String("owner"),
+					timestamp: c.Bool("timestamp"),
+					template:  c.String("template"),
+				}
+				if err := initAction(c.Args(), c.GlobalString("keydir"), opts); err != nil {
+					fmt.Fprintln(os.Stderr, "Initialization failed:", err)
+					os.Exit(exitCode(err))
+				}
+			},
+		},
+		{
+			Name:  "keys",
+			Usage: "manage the keys in the keydir",
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "list the keys in the keydir, checking that each is valid",
+					Action: func(c *cli.Context) {
+						if err := keysListAction(c.GlobalString("keydir")); err != nil {
+							fmt.Fprintln(os.Stderr, "Key check failed:", err)
+							os.Exit(exitCode(err))
+						}
+					},
+				},
+				{
+					Name:      "which",
+					Usage:     "show which key in the keydir decrypts each of one or more EJSON files",
+					ArgsUsage: "<file>...",
+					Action: func(c *cli.Context) {
+						if err := keysWhichAction(c.Args(), c.GlobalString("keydir")); err != nil {
+							fmt.Fprintln(os.Stderr, "Key lookup failed:", err)
+							os.Exit(exitCode(err))
+						}
+					},
+				},
+				{
+					Name:      "split",
+					Usage:     "split a private key into shares, some number of which can recover it",
+					ArgsUsage: "<public key>",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "shares",
+							Value: 5,
+							Usage: "the number of shares to make",
+						},
+						cli.IntFlag{
+							Name:  "threshold",
+							Value: 3,
+							Usage: "the number of shares needed to recover the key",
+						},
+						cli.StringFlag{
+							Name:  "dir",
+							Value: ".",
+							Usage: "the directory to write the shares into",
+						},
+						cli.BoolFlag{
+							Name:  "key-from-stdin",
+							Usage: "Read the private key from STDIN",
+						},
+					},
+					Action: func(c *cli.Context) {
+						userSuppliedPrivateKey := readKeyFromStdin(c)
+						if err := keysSplitAction(c.Args(), c.GlobalString("keydir"), userSuppliedPrivateKey, c.Int("shares"), c.Int("threshold"), c.String("dir")); err != nil {
+							fmt.Fprintln(os.Stderr, "Key splitting failed:", err)
+							os.Exit(exitCode(err))
+						}
+					},
+				},
+				{
+					Name:      "combine",
+					Usage:     "recover a private key from shares made by split, and write it into the keydir",
+					ArgsUsage: "<share file>...",
+					Action: func(c *cli.Context) {
+						if err := keysCombineAction(c.Args(), c.GlobalString("keydir")); err != nil {
+							fmt.Fprintln(os.Stderr, "Key recovery failed:", err)
+							os.Exit(exitCode(err))
+						}
+					},
+				},
+				{
+					Name:  "prune",
+					Usage: "list (or remove) keys not used by any file in the given directories",
+					Flags: []cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "unused-by",
+							Usage: "a directory to search for files using the keys (may be repeated)",
+						},
+						cli.StringSliceFlag{
+							Name:  "glob",
+							Usage: "only search files whose names match this pattern (default: every file, even if ignored by git; may be repeated)",
+						},
+						cli.BoolFlag{
+							Name:  "remove",
+							Usage: "remove the unused keys, rather than listing them",
+						},
+					},
+					Action: func(c *cli.Context) {
+						if err := keysPruneAction(c.GlobalString("keydir"), c.StringSlice("unused-by"), c.StringSlice("glob"), c.Bool("remove")); err != nil {
+							fmt.Fprintln(os.Stderr, "Key pruning failed:", err)
+							os.Exit(exitCode(err))
+						}
+					},
+				},
+			},
+		},
 		{
 			Name:      "keygen",
 			ShortName: "g",
@@ -78,11 +576,40 @@ func main() {
 					Name:  "write, w",
 					Usage: "rather than printing both keys, print the public and write the private into the keydir",
 				},
+				cli.StringFlag{
+					Name:  "from-ssh",
+					Usage: "derive the keypair from an OpenSSH ed25519 private key, or print the public keys for an authorized_keys file",
+				},
+				cli.BoolFlag{
+					Name:  "passphrase-from-stdin",
+					Usage: "with --from-ssh, read the passphrase of the SSH key from STDIN",
+				},
+				cli.StringFlag{
+					Name:  "from-age",
+					Usage: "use the keypair of an age X25519 identity file, as written by age-keygen",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Value: "hex",
+					Usage: "print the keys as hex, or as an age recipient and identity (age)",
+				},
+				cli.BoolFlag{
+					Name:  "signing",
+					Usage: "generate a keypair for signing files, rather than for encrypting them",
+				},
 			},
 			Action: func(c *cli.Context) {
-				if err := keygenAction(c.Args(), c.GlobalString("keydir"), c.Bool("write")); err != nil {
+				opts := keygenOptions{
+					write:   c.Bool("write"),
+					fromSSH: c.String("from-ssh"),
+					fromAge: c.String("from-age"),
+					signing: c.Bool("signing"),
+					format:  c.String("format"),
+				}
+				opts.passphrase = readPassphraseFromStdin(c)
+				if err := keygenAction(c.Args(), c.GlobalString("keydir"), opts); err != nil {
 					fmt.Fprintln(os.Stderr, "Key generation failed:", err)
-					os.Exit(1)
+					os.Exit(exitCode(err))
 				}
 			},
 		},
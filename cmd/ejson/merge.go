@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Shopify/ejson"
+	"github.com/Shopify/ejson/internal/atomicfile"
+)
+
+// mergeDriverAction implements a git merge driver: it merges the changes in
+// the base, ours and theirs versions of a file into ours, as git expects.
+func mergeDriverAction(args []string, keydir, auditLog string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("exactly three files must be given: the base, ours and theirs (%%O %%A %%B)")
+	}
+	audit, closeAuditLog, err := openAudit(auditLog)
+	if err != nil {
+		return err
+	}
+	defer closeAuditLog()
+	opts := ejson.DiffOptions{Keydir: keydir, Audit: audit}
+	for _, arg := range args {
+		if abs, err := filepath.Abs(arg); err == nil {
+			arg = abs
+		}
+		opts.Files = append(opts.Files, arg)
+	}
+
+	base, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	theirs, err := os.ReadFile(args[2])
+	if err != nil {
+		return err
+	}
+
+	var conflicts []string
+	_, err = atomicfile.Update(args[1], func(ours []byte) ([]byte, error) {
+		var merged []byte
+		var err error
+		merged, conflicts, err = ejson.MergeDocuments(bytes.NewReader(base), bytes.NewReader(ours), bytes.NewReader(theirs), opts)
+		return merged, err
+	})
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("conflicting changes to %s", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
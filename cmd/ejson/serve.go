@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Shopify/ejson"
+	"github.com/Shopify/ejson/server"
+)
+
+// serveOptions describes where serveAction listens, and for whom it decrypts.
+type serveOptions struct {
+	socket      string
+	socketMode  string
+	listen      string
+	tlsCert     string
+	tlsKey      string
+	clientCA    string
+	policy      string
+	auditLog    string
+	trustedKeys string
+}
+
+func serveAction(keydir string, opts serveOptions) error {
+	if opts.socket == "" && opts.listen == "" {
+		return fmt.Errorf("--socket or --listen must be given")
+	}
+	if opts.listen != "" && (opts.tlsCert == "" || opts.tlsKey == "" || opts.clientCA == "" || opts.policy == "") {
+		return fmt.Errorf("--listen requires --tls-cert, --tls-key, --client-ca and --authorized-clients")
+	}
+	perm, err := parseMode(opts.socketMode)
+	if err != nil {
+		return err
+	}
+
+	config := server.Config{Keys: ejson.Keydir(keydir)}
+	if opts.policy != "" {
+		if config.Policy, err = server.LoadPolicy(opts.policy); err != nil {
+			return err
+		}
+	}
+	if config.TrustedSigners, err = loadTrustedKeys(opts.trustedKeys); err != nil {
+		return err
+	}
+	auditLog, err := openAuditLog(opts.auditLog)
+	if err != nil {
+		return err
+	}
+	config.Audit = ejson.JSONAuditLog(auditLog)
+
+	var listeners []net.Listener
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	if opts.socket != "" {
+		l, err := listenUnix(opts.socket, perm)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+	if opts.listen != "" {
+		tlsConfig, err := serverTLSConfig(opts.tlsCert, opts.tlsKey, opts.clientCA)
+		if err != nil {
+			return err
+		}
+		l, err := tls.Listen("tcp", opts.listen, tlsConfig)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	s := server.New(config)
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		fmt.Fprintf(os.Stderr, "Listening on %s\n", l.Addr())
+		go func(l net.Listener) { errs <- s.Serve(ctx, l) }(l)
+	}
+	// Stop every listener as soon as one of them fails.
+	var firstErr error
+	for range listeners {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+			stop()
+		}
+	}
+	return firstErr
+}
+
+// openAuditLog opens the audit log for appending, or returns stderr if path
+// is empty or "-".
+func openAuditLog(path string) (io.Writer, error) {
+	if path == "" || path == "-" {
+		return os.Stderr, nil
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+}
+
+// listenUnix listens on a Unix socket at path with the given mode, replacing
+// a socket left behind by an earlier run, but nothing else.
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s already exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	// Create the socket with its final mode, so that nobody else can connect
+	// before it's narrowed.
+	var l net.Listener
+	err := withUmask(perm, func() (err error) {
+		l, err = net.Listen("unix", path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// serverTLSConfig requires clients to present a certificate signed by one of
+// the CAs in clientCA.
+func serverTLSConfig(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := os.ReadFile(clientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", clientCA)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestListenUnix(t *testing.T) {
+	Convey("listenUnix", t, func() {
+		path := filepath.Join(t.TempDir(), "ejson.sock")
+
+		Convey("creates the socket with the given mode", func() {
+			l, err := listenUnix(path, 0o600)
+			So(err, ShouldBeNil)
+			defer l.Close()
+			info, err := os.Stat(path)
+			So(err, ShouldBeNil)
+			if runtime.GOOS != "windows" {
+				So(info.Mode().Perm(), ShouldEqual, os.FileMode(0o600))
+			}
+		})
+
+		Convey("refuses to replace anything but a socket", func() {
+			So(os.WriteFile(path, nil, 0o600), ShouldBeNil)
+			_, err := listenUnix(path, 0o600)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/Shopify/ejson"
+	"golang.org/x/crypto/ssh"
+)
+
+func signAction(args []string, keyPath string, passphrase []byte) error {
+	if len(args) < 1 {
+		return fmt.Errorf("at least one file path must be given")
+	}
+	if keyPath == "" {
+		return fmt.Errorf("--key must be given")
+	}
+	key, err := ejson.LoadSigningKey(keyPath, passphrase)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return fmt.Errorf("%s is encrypted; pass its passphrase with --passphrase-from-stdin", keyPath)
+	} else if err != nil {
+		return err
+	}
+	return forEachFile(args, func(filePath string) (string, error) {
+		n, err := ejson.SignFileInPlace(filePath, key)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Wrote %d bytes to %s.", n, filePath), nil
+	})
+}
+
+func verifySignatureAction(args []string, trustedKeys string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("at least one file path must be given")
+	}
+	// Without trusted keys, anyone who can change a file could sign it, so
+	// a valid signature alone proves nothing.
+	if trustedKeys == "" {
+		return fmt.Errorf("--trusted-keys must be given, or EJSON_TRUSTED_KEYS set")
+	}
+	trusted, err := loadTrustedKeys(trustedKeys)
+	if err != nil {
+		return err
+	}
+	return forEachFile(args, func(filePath string) (string, error) {
+		signer, err := ejson.VerifySignatureFile(filePath, trusted)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s: signed by %s", filePath, signer), nil
+	})
+}
+
+// loadTrustedKeys loads the keys of trusted signers from the directory given
+// with --trusted-keys, if one was.
+func loadTrustedKeys(dir string) ([]ed25519.PublicKey, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	return ejson.LoadTrustedKeys(dir)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/ejson"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVerifySignatureAction(t *testing.T) {
+	Convey("verifySignatureAction", t, func() {
+		dir := t.TempDir()
+		signerPub, signer, err := ed25519.GenerateKey(rand.Reader)
+		So(err, ShouldBeNil)
+		otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+		So(err, ShouldBeNil)
+
+		var encrypted, signed bytes.Buffer
+		_, err = ejson.Encrypt(strings.NewReader(`{"_public_key": "8d8647e2eeb6d2e31228e6df7da3df921ec3b799c3f66a171cd37a1ed3004e7d", "a": "b"}`), &encrypted)
+		So(err, ShouldBeNil)
+		_, err = ejson.Sign(&encrypted, &signed, signer)
+		So(err, ShouldBeNil)
+		path := filepath.Join(dir, "app.ejson")
+		So(os.WriteFile(path, signed.Bytes(), 0o644), ShouldBeNil)
+
+		trust := func(key ed25519.PublicKey) string {
+			trusted := filepath.Join(dir, "trusted-"+hex.EncodeToString(key[:4]))
+			So(os.Mkdir(trusted, 0o755), ShouldBeNil)
+			So(os.WriteFile(filepath.Join(trusted, "keys"), []byte(hex.EncodeToString(key)+"\n"), 0o644), ShouldBeNil)
+			return trusted
+		}
+
+		Convey("accepts a file signed by a trusted key", func() {
+			So(verifySignatureAction([]string{path}, trust(signerPub)), ShouldBeNil)
+		})
+
+		Convey("rejects a file signed by an untrusted key", func() {
+			err := verifySignatureAction([]string{path}, trust(otherPub))
+			So(errors.Is(err, ejson.ErrUntrustedSigner), ShouldBeTrue)
+		})
+
+		Convey("fails without any trusted keys", func() {
+			So(verifySignatureAction([]string{path}, ""), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// There's no umask to set here.
+func withUmask(_ os.FileMode, fn func() error) error {
+	return fn()
+}
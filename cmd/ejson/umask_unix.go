@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// withUmask runs fn with a umask that leaves only the permissions in perm, so
+// that anything fn creates never has more, even for a moment. The umask is
+// process-wide, so fn shouldn't run alongside other code creating files.
+func withUmask(perm os.FileMode, fn func() error) error {
+	old := syscall.Umask(int(0o777 &^ perm.Perm()))
+	defer syscall.Umask(old)
+	return fn()
+}
@@ -0,0 +1,202 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// age (https://age-encryption.org/v1) uses Curve25519 keys too, so an ejson
+// keypair is also an age X25519 identity, and its public key an age recipient.
+// They only differ in how they're written down.
+
+const (
+	ageRecipientHRP = "age"
+	ageIdentityHRP  = "AGE-SECRET-KEY-"
+
+	ageHeader      = "age-encryption.org/v1"
+	ageX25519Label = "age-encryption.org/v1/X25519"
+	ageChunkSize   = 64 * 1024
+	ageFileKeySize = 16
+	ageStreamNonce = 16
+	ageColumns     = 64
+)
+
+// ErrInvalidAgeKey means that a string that looked like an age recipient or
+// identity couldn't be decoded.
+var ErrInvalidAgeKey = errors.New("invalid age key")
+
+var ageBase64 = base64.RawStdEncoding
+
+// IsAgeRecipient reports whether s looks like an age X25519 recipient, as
+// opposed to a hex-encoded public key.
+func IsAgeRecipient(s string) bool {
+	return strings.HasPrefix(s, ageRecipientHRP+"1")
+}
+
+// IsAgeIdentity reports whether s looks like an age X25519 identity, as
+// opposed to a hex-encoded private key.
+func IsAgeIdentity(s string) bool {
+	return strings.HasPrefix(s, ageIdentityHRP+"1")
+}
+
+// ParseAgeRecipient decodes an age X25519 recipient (age1...) into a public
+// key.
+func ParseAgeRecipient(s string) ([32]byte, error) {
+	return parseAgeKey(s, ageRecipientHRP)
+}
+
+// ParseAgeIdentity decodes an age X25519 identity (AGE-SECRET-KEY-1...) into
+// a private key.
+func ParseAgeIdentity(s string) ([32]byte, error) {
+	return parseAgeKey(s, strings.ToLower(ageIdentityHRP))
+}
+
+func parseAgeKey(s, wantHRP string) (key [32]byte, err error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return key, fmt.Errorf("%w: %w", ErrInvalidAgeKey, err)
+	}
+	if hrp != wantHRP || len(data) != len(key) {
+		return key, ErrInvalidAgeKey
+	}
+	copy(key[:], data)
+	return key, nil
+}
+
+// AgeRecipient returns the public key in the form of an age X25519 recipient.
+func (k *Keypair) AgeRecipient() string {
+	s, _ := bech32Encode(ageRecipientHRP, k.Public[:])
+	return s
+}
+
+// AgeIdentity returns the private key in the form of an age X25519 identity.
+func (k *Keypair) AgeIdentity() string {
+	s, _ := bech32Encode(ageIdentityHRP, k.Private[:])
+	return s
+}
+
+// EncryptAge encrypts plaintext to each of the given Curve25519 public keys
+// in the age v1 format, so that it can be decrypted by age (or any other
+// implementation) with the matching identity.
+func EncryptAge(plaintext []byte, recipients ...[32]byte) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("at least one recipient is required")
+	}
+
+	fileKey := make([]byte, ageFileKeySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, err
+	}
+
+	var header bytes.Buffer
+	header.WriteString(ageHeader + "\n")
+	for _, recipient := range recipients {
+		share, body, err := ageWrapX25519(fileKey, recipient)
+		if err != nil {
+			return nil, err
+		}
+		header.WriteString("-> X25519 " + ageBase64.EncodeToString(share) + "\n")
+		writeAgeBody(&header, body)
+	}
+	header.WriteString("---")
+	mac := hmac.New(sha256.New, ageHKDF(fileKey, nil, "header"))
+	mac.Write(header.Bytes())
+	header.WriteString(" " + ageBase64.EncodeToString(mac.Sum(nil)) + "\n")
+
+	nonce := make([]byte, ageStreamNonce)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header.Write(nonce)
+	payload, err := ageSealStream(ageHKDF(fileKey, nonce, "payload"), plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return append(header.Bytes(), payload...), nil
+}
+
+// ageWrapX25519 encrypts the file key to recipient, returning the ephemeral
+// public key and the body of the stanza.
+func ageWrapX25519(fileKey []byte, recipient [32]byte) (share, body []byte, err error) {
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return nil, nil, err
+	}
+	share, err = curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	shared, err := curve25519.X25519(ephemeral, recipient[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	salt := append(append([]byte{}, share...), recipient[:]...)
+	aead, err := chacha20poly1305.New(ageHKDF(shared, salt, ageX25519Label))
+	if err != nil {
+		return nil, nil, err
+	}
+	body = aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil)
+	return share, body, nil
+}
+
+// writeAgeBody writes a stanza body in base64, wrapped into lines of 64
+// columns. The last line is always shorter than that, even if it's empty.
+func writeAgeBody(w *bytes.Buffer, body []byte) {
+	encoded := ageBase64.EncodeToString(body)
+	for len(encoded) >= ageColumns {
+		w.WriteString(encoded[:ageColumns] + "\n")
+		encoded = encoded[ageColumns:]
+	}
+	w.WriteString(encoded + "\n")
+}
+
+// ageSealStream encrypts plaintext with the STREAM construction age uses for
+// its payload: 64 KiB chunks, each sealed with a nonce made of the chunk's
+// index and a flag marking the last chunk.
+func ageSealStream(key, plaintext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(plaintext)+(len(plaintext)/ageChunkSize+1)*aead.Overhead())
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	for counter := uint64(0); ; counter++ {
+		chunk := plaintext
+		if len(chunk) > ageChunkSize {
+			chunk = chunk[:ageChunkSize]
+		}
+		plaintext = plaintext[len(chunk):]
+		last := len(plaintext) == 0
+
+		for i := 0; i < 8; i++ {
+			nonce[10-i] = byte(counter >> (8 * i))
+		}
+		if last {
+			nonce[11] = 1
+		}
+		out = aead.Seal(out, nonce, chunk, nil)
+		if last {
+			return out, nil
+		}
+	}
+}
+
+func ageHKDF(secret, salt []byte, info string) []byte {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		panic(err)
+	}
+	return key
+}
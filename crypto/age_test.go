@@ -0,0 +1,217 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+func TestBech32(t *testing.T) {
+	Convey("Bech32", t, func() {
+		Convey("should accept the valid BIP 173 test vectors", func() {
+			for _, s := range []string{
+				"A12UEL5L",
+				"a12uel5l",
+				"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+				"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+				"?1ezyfcl",
+			} {
+				_, _, err := bech32Decode(s)
+				So(err, ShouldBeNil)
+			}
+		})
+		Convey("should reject invalid strings", func() {
+			for _, s := range []string{
+				"A12Uel5l",      // mixed case
+				"a12uel5m",      // bad checksum
+				"1pzry9x0s0muk", // empty hrp
+				"pzry9x0s0muk",  // no separator
+				"abc1b",         // too short
+			} {
+				_, _, err := bech32Decode(s)
+				So(err, ShouldNotBeNil)
+			}
+		})
+		Convey("should round trip data", func() {
+			data := []byte("some data to encode")
+			s, err := bech32Encode("test", data)
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, strings.ToLower(s))
+			hrp, decoded, err := bech32Decode(s)
+			So(err, ShouldBeNil)
+			So(hrp, ShouldEqual, "test")
+			So(decoded, ShouldResemble, data)
+
+			s, err = bech32Encode("TEST", data)
+			So(err, ShouldBeNil)
+			So(s, ShouldEqual, strings.ToUpper(s))
+		})
+	})
+}
+
+func TestAgeKeys(t *testing.T) {
+	Convey("age keys", t, func() {
+		var kp Keypair
+		So(kp.Generate(), ShouldBeNil)
+
+		recipient, identity := kp.AgeRecipient(), kp.AgeIdentity()
+		So(IsAgeRecipient(recipient), ShouldBeTrue)
+		So(IsAgeIdentity(identity), ShouldBeTrue)
+		So(IsAgeRecipient(kp.PublicString()), ShouldBeFalse)
+
+		pub, err := ParseAgeRecipient(recipient)
+		So(err, ShouldBeNil)
+		So(pub, ShouldResemble, kp.Public)
+		priv, err := ParseAgeIdentity(identity)
+		So(err, ShouldBeNil)
+		So(priv, ShouldResemble, kp.Private)
+
+		Convey("should not confuse recipients and identities", func() {
+			_, err := ParseAgeRecipient(identity)
+			So(errors.Is(err, ErrInvalidAgeKey), ShouldBeTrue)
+			_, err = ParseAgeIdentity(recipient)
+			So(errors.Is(err, ErrInvalidAgeKey), ShouldBeTrue)
+		})
+		Convey("should reject corrupted keys", func() {
+			corrupt := []byte(recipient)
+			corrupt[len(corrupt)-1] ^= 1
+			_, err := ParseAgeRecipient(string(corrupt))
+			So(errors.Is(err, ErrInvalidAgeKey), ShouldBeTrue)
+		})
+	})
+}
+
+func TestEncryptAge(t *testing.T) {
+	Convey("Encrypting to age recipients", t, func() {
+		var alice, bob, eve Keypair
+		So(alice.Generate(), ShouldBeNil)
+		So(bob.Generate(), ShouldBeNil)
+		So(eve.Generate(), ShouldBeNil)
+
+		for _, size := range []int{0, 10, ageChunkSize, 2*ageChunkSize + 5} {
+			plaintext := bytes.Repeat([]byte("x"), size)
+			encrypted, err := EncryptAge(plaintext, alice.Public, bob.Public)
+			So(err, ShouldBeNil)
+			So(string(encrypted), ShouldStartWith, "age-encryption.org/v1\n-> X25519 ")
+
+			for _, kp := range []Keypair{alice, bob} {
+				decrypted, err := openAge(encrypted, kp.Private)
+				So(err, ShouldBeNil)
+				So(string(decrypted), ShouldEqual, string(plaintext))
+			}
+			_, err = openAge(encrypted, eve.Private)
+			So(err, ShouldNotBeNil)
+		}
+
+		Convey("should require a recipient", func() {
+			_, err := EncryptAge([]byte("x"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// openAge is an independent reading of the age v1 format, used to check that
+// EncryptAge writes what the specification describes.
+func openAge(data []byte, identity [32]byte) ([]byte, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	var header bytes.Buffer
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		header.WriteString(line)
+		return strings.TrimSuffix(line, "\n"), err
+	}
+
+	if line, err := readLine(); err != nil || line != "age-encryption.org/v1" {
+		return nil, fmt.Errorf("bad version line %q", line)
+	}
+	ourShare, err := curve25519.X25519(identity[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	var fileKey []byte
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(line, "--- ") {
+			header.Truncate(header.Len() - len(line) - 1 + len("---"))
+			mac := hmac.New(sha256.New, ageHKDF(fileKey, nil, "header"))
+			mac.Write(header.Bytes())
+			want, err := ageBase64.DecodeString(strings.TrimPrefix(line, "--- "))
+			if err != nil || fileKey == nil || !hmac.Equal(mac.Sum(nil), want) {
+				return nil, errors.New("bad header MAC")
+			}
+			break
+		}
+		args := strings.Fields(strings.TrimPrefix(line, "-> "))
+		if len(args) != 2 || args[0] != "X25519" {
+			return nil, fmt.Errorf("bad stanza %q", line)
+		}
+		share, err := ageBase64.DecodeString(args[1])
+		if err != nil {
+			return nil, err
+		}
+		var body []byte
+		for {
+			bodyLine, err := readLine()
+			if err != nil {
+				return nil, err
+			}
+			chunk, err := ageBase64.DecodeString(bodyLine)
+			if err != nil {
+				return nil, err
+			}
+			body = append(body, chunk...)
+			if len(bodyLine) < 64 {
+				break
+			}
+		}
+		shared, err := curve25519.X25519(identity[:], share)
+		if err != nil {
+			return nil, err
+		}
+		aead, _ := chacha20poly1305.New(ageHKDF(shared, append(share, ourShare...), "age-encryption.org/v1/X25519"))
+		if key, err := aead.Open(nil, make([]byte, 12), body, nil); err == nil {
+			fileKey = key
+		}
+	}
+
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	aead, _ := chacha20poly1305.New(ageHKDF(fileKey, rest[:16], "payload"))
+	rest = rest[16:]
+	var plaintext []byte
+	for counter := byte(0); ; counter++ {
+		n := len(rest)
+		if n > 64*1024+16 {
+			n = 64*1024 + 16
+		}
+		nonce := make([]byte, 12)
+		nonce[10] = counter
+		if n == len(rest) {
+			nonce[11] = 1
+		}
+		chunk, err := aead.Open(nil, nonce, rest[:n], nil)
+		if err != nil {
+			return nil, err
+		}
+		plaintext = append(plaintext, chunk...)
+		rest = rest[n:]
+		if len(rest) == 0 {
+			return plaintext, nil
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package crypto
+
+import (
+	"errors"
+	"strings"
+)
+
+// This is the Bech32 encoding of BIP 173, as used by age for its keys. Unlike
+// BIP 173, it doesn't limit the length of the encoded string.
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+var errBech32 = errors.New("invalid bech32 string")
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range bech32Generator {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	out := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+// convertBits regroups data from groups of frombits bits into groups of
+// tobits bits.
+func convertBits(data []byte, frombits, tobits uint, pad bool) ([]byte, error) {
+	var (
+		acc  uint32
+		bits uint
+		out  []byte
+		maxv = uint32(1)<<tobits - 1
+	)
+	for _, b := range data {
+		if uint32(b)>>frombits != 0 {
+			return nil, errBech32
+		}
+		acc = acc<<frombits | uint32(b)
+		bits += frombits
+		for bits >= tobits {
+			bits -= tobits
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(tobits-bits)&maxv))
+		}
+	} else if bits >= frombits || acc<<(tobits-bits)&maxv != 0 {
+		return nil, errBech32
+	}
+	return out, nil
+}
+
+// bech32Encode encodes data with the human-readable part hrp. The result is
+// lowercase if hrp is, and uppercase otherwise.
+func bech32Encode(hrp string, data []byte) (string, error) {
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	lower := strings.ToLower(hrp)
+	check := append(bech32HRPExpand(lower), values...)
+	check = append(check, 0, 0, 0, 0, 0, 0)
+	mod := bech32Polymod(check) ^ 1
+
+	var out strings.Builder
+	out.WriteString(lower)
+	out.WriteByte('1')
+	for _, v := range values {
+		out.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		out.WriteByte(bech32Charset[mod>>uint(5*(5-i))&31])
+	}
+	if lower != hrp {
+		return strings.ToUpper(out.String()), nil
+	}
+	return out.String(), nil
+}
+
+// bech32Decode decodes s, returning its human-readable part in lowercase, and
+// its data.
+func bech32Decode(s string) (hrp string, data []byte, err error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errBech32
+	}
+	s = strings.ToLower(s)
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, errBech32
+	}
+	hrp = s[:sep]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, errBech32
+		}
+	}
+	values := make([]byte, 0, len(s)-sep-1)
+	for i := sep + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, errBech32
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errBech32
+	}
+	data, err = convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
@@ -2,19 +2,51 @@ package crypto
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
-var messageParser = regexp.MustCompile("\\AEJ\\[(\\d):([A-Za-z0-9+=/]{44}):([A-Za-z0-9+=/]{32}):(.+)\\]\\z")
+// ErrMalformedCiphertext means that a value which should have been an
+// encrypted message wasn't in the boxedMessage wire format.
+var ErrMalformedCiphertext = errors.New("invalid message format")
+
+// ErrUnsupportedSchema means that an encrypted message uses a schema version
+// or feature flag that this version of ejson doesn't know how to decrypt.
+var ErrUnsupportedSchema = errors.New("unsupported message schema")
+
+var messageParser = regexp.MustCompile("\\AEJ\\[(\\d)(?::([a-z]*))?:([A-Za-z0-9+=/]{44}):([A-Za-z0-9+=/]{32}):(.+)\\]\\z")
+
+// Schema versions. Version 1 messages contain nothing but the sealed
+// plaintext. Version 2 messages add a field of feature flags, which is also
+// prepended to the sealed plaintext (followed by a ':') so that it can't be
+// tampered with. Decrypters that predate version 2 reject such messages as
+// malformed, rather than misinterpreting them.
+const (
+	schemaV1 = 1
+	schemaV2 = 2
+)
+
+// Feature flags for schema version 2 messages. At most one of the type flags
+// may be present; messages without one contain a string.
+const (
+	flagNumber     = 'n' // the plaintext is a JSON number literal
+	flagBoolean    = 'b' // the plaintext is a JSON boolean literal
+	flagNull       = 'z' // the plaintext is a JSON null literal
+	flagPadded     = 'p' // the plaintext is padded to hide its length
+	flagCompressed = 'c' // the plaintext is compressed with deflate (before any padding)
+)
 
 // boxedMessage dumps and loads the wire format for encrypted messages. The
 // schema is fairly simple:
 //
 //	"EJ["
-//	SchemaVersion ( "1" )
+//	SchemaVersion ( "1" | "2" )
 //	":"
+//	[ Flags :: zero or more lowercase letters (version 2 only)
+//	  ":" ]
 //	EncrypterPublic :: base64-encoded 32-byte key
 //	":"
 //	Nonce :: base64-encoded 24-byte nonce
@@ -23,11 +55,17 @@ var messageParser = regexp.MustCompile("\\AEJ\\[(\\d):([A-Za-z0-9+=/]{44}):([A-Z
 //	"]"
 type boxedMessage struct {
 	SchemaVersion   int
+	Flags           string
 	EncrypterPublic [32]byte
 	Nonce           [24]byte
 	Box             []byte
 }
 
+// hasFlag reports whether the message has the given feature flag set.
+func (b *boxedMessage) hasFlag(flag byte) bool {
+	return strings.IndexByte(b.Flags, flag) >= 0
+}
+
 // IsBoxedMessage tests whether a value is formatted using the boxedMessage
 // format. This can be used to determine whether a string value requires
 // encryption or is already encrypted.
@@ -41,6 +79,10 @@ func (b *boxedMessage) Dump() []byte {
 	nonce := base64.StdEncoding.EncodeToString(b.Nonce[:])
 	box := base64.StdEncoding.EncodeToString(b.Box)
 
+	if b.SchemaVersion >= schemaV2 {
+		return []byte(fmt.Sprintf("EJ[%d:%s:%s:%s:%s]",
+			b.SchemaVersion, b.Flags, pub, nonce, box))
+	}
 	str := fmt.Sprintf("EJ[%d:%s:%s:%s]",
 		b.SchemaVersion, pub, nonce, box)
 	return []byte(str)
@@ -48,35 +90,51 @@ func (b *boxedMessage) Dump() []byte {
 
 // Load restores from the wire format.
 func (b *boxedMessage) Load(from []byte) error {
-	var ssver, spub, snonce, sbox string
+	var ssver, sflags, spub, snonce, sbox string
 	var err error
 
 	allMatches := messageParser.FindAllStringSubmatch(string(from), -1) // -> [][][]byte
 	if len(allMatches) != 1 {
-		return fmt.Errorf("invalid message format")
+		return ErrMalformedCiphertext
 	}
 	matches := allMatches[0]
-	if len(matches) != 5 {
-		return fmt.Errorf("invalid message format")
+	if len(matches) != 6 {
+		return ErrMalformedCiphertext
 	}
 
 	ssver = matches[1]
-	spub = matches[2]
-	snonce = matches[3]
-	sbox = matches[4]
+	sflags = matches[2]
+	spub = matches[3]
+	snonce = matches[4]
+	sbox = matches[5]
 
 	b.SchemaVersion, err = strconv.Atoi(ssver)
 	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedCiphertext, err)
+	}
+	// The flags group is optional, so check whether it took part in the match
+	// at all, rather than whether it matched an empty string.
+	hasFlags := messageParser.FindStringSubmatchIndex(string(from))[4] >= 0
+	switch {
+	case b.SchemaVersion == schemaV1 && hasFlags:
+		return fmt.Errorf("%w: flags require schema version %d", ErrMalformedCiphertext, schemaV2)
+	case b.SchemaVersion == schemaV2 && !hasFlags:
+		return fmt.Errorf("%w: missing flags", ErrMalformedCiphertext)
+	case b.SchemaVersion < schemaV1 || b.SchemaVersion > schemaV2:
+		return fmt.Errorf("%w: version %d", ErrUnsupportedSchema, b.SchemaVersion)
+	}
+	if err := checkFlags(sflags); err != nil {
 		return err
 	}
+	b.Flags = sflags
 
 	pub, err := base64.StdEncoding.DecodeString(spub)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrMalformedCiphertext, err)
 	}
 	pubBytes := []byte(pub)
 	if len(pubBytes) != 32 {
-		return fmt.Errorf("public key invalid")
+		return fmt.Errorf("%w: public key invalid", ErrMalformedCiphertext)
 	}
 	var public [32]byte
 	copy(public[:], pubBytes[0:32])
@@ -84,11 +142,11 @@ func (b *boxedMessage) Load(from []byte) error {
 
 	nnc, err := base64.StdEncoding.DecodeString(snonce)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrMalformedCiphertext, err)
 	}
 	nonceBytes := []byte(nnc)
 	if len(nonceBytes) != 24 {
-		return fmt.Errorf("nonce invalid")
+		return fmt.Errorf("%w: nonce invalid", ErrMalformedCiphertext)
 	}
 	var nonce [24]byte
 	copy(nonce[:], nonceBytes[0:24])
@@ -96,9 +154,31 @@ func (b *boxedMessage) Load(from []byte) error {
 
 	box, err := base64.StdEncoding.DecodeString(sbox)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrMalformedCiphertext, err)
 	}
 	b.Box = []byte(box)
 
 	return nil
 }
+
+// checkFlags returns an error if flags contains anything other than a known
+// combination of feature flags.
+func checkFlags(flags string) error {
+	types := 0
+	for i, f := range []byte(flags) {
+		if strings.IndexByte(flags[:i], f) >= 0 {
+			return fmt.Errorf("%w: repeated flag %q", ErrMalformedCiphertext, f)
+		}
+		switch f {
+		case flagNumber, flagBoolean, flagNull:
+			types++
+		case flagPadded, flagCompressed:
+		default:
+			return fmt.Errorf("%w: flag %q", ErrUnsupportedSchema, f)
+		}
+	}
+	if types > 1 {
+		return fmt.Errorf("%w: conflicting type flags %q", ErrMalformedCiphertext, flags)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"errors"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -29,6 +30,44 @@ func TestBoxedMessageRoundtripping(t *testing.T) {
 			So(bm.Box, ShouldResemble, []byte{3, 3, 3})
 		})
 
+		Convey("Dump and Load with flags", func() {
+			bm := boxedMessage{
+				SchemaVersion:   2,
+				Flags:           "n",
+				EncrypterPublic: pk,
+				Nonce:           nonce,
+				Box:             []byte{3, 3, 3},
+			}
+			wire2 := "EJ[2:n:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=:AgICAgICAgICAgICAgICAgICAgICAgIC:AwMD]"
+			So(string(bm.Dump()), ShouldEqual, wire2)
+
+			loaded := boxedMessage{}
+			So(loaded.Load([]byte(wire2)), ShouldBeNil)
+			So(loaded, ShouldResemble, bm)
+		})
+
+		Convey("Load rejects unknown versions and flags", func() {
+			bm := boxedMessage{}
+			err := bm.Load([]byte("EJ[3:n:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=:AgICAgICAgICAgICAgICAgICAgICAgIC:AwMD]"))
+			So(errors.Is(err, ErrUnsupportedSchema), ShouldBeTrue)
+			err = bm.Load([]byte("EJ[2:q:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=:AgICAgICAgICAgICAgICAgICAgICAgIC:AwMD]"))
+			So(errors.Is(err, ErrUnsupportedSchema), ShouldBeTrue)
+			err = bm.Load([]byte("EJ[1:n:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=:AgICAgICAgICAgICAgICAgICAgICAgIC:AwMD]"))
+			So(errors.Is(err, ErrMalformedCiphertext), ShouldBeTrue)
+			err = bm.Load([]byte("EJ[2:pp:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=:AgICAgICAgICAgICAgICAgICAgICAgIC:AwMD]"))
+			So(errors.Is(err, ErrMalformedCiphertext), ShouldBeTrue)
+		})
+
+		Convey("InspectMessage", func() {
+			info, err := InspectMessage([]byte(wire))
+			So(err, ShouldBeNil)
+			So(info, ShouldResemble, MessageInfo{SchemaVersion: 1, EncrypterPublic: pk, Length: 3})
+			So(info.Fingerprint(), ShouldHaveLength, 8)
+
+			_, err = InspectMessage([]byte("EJ[]"))
+			So(errors.Is(err, ErrMalformedCiphertext), ShouldBeTrue)
+		})
+
 		Convey("IsBoxedMessage", func() {
 			So(IsBoxedMessage([]byte(wire)), ShouldBeTrue)
 			So(IsBoxedMessage([]byte("nope")), ShouldBeFalse)
@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+)
+
+// maxDecompressedSize caps the size a compressed message may decompress to,
+// so that a small, maliciously crafted value can't exhaust memory.
+const maxDecompressedSize = 32 << 20
+
+// ErrValueTooLarge means that a compressed message would decompress to more
+// than maxDecompressedSize bytes.
+var ErrValueTooLarge = errors.New("decompressed value is too large")
+
+// compress deflates a message, returning ok = false if that doesn't make it
+// any smaller.
+func compress(message []byte) (compressed []byte, ok bool) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := w.Write(message); err != nil {
+		return nil, false
+	}
+	if err := w.Close(); err != nil {
+		return nil, false
+	}
+	if buf.Len() >= len(message) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompress reverses compress, refusing to produce more than
+// maxDecompressedSize bytes.
+func decompress(compressed []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(compressed))
+	defer r.Close()
+	message, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	if len(message) > maxDecompressedSize {
+		return nil, ErrValueTooLarge
+	}
+	return message, nil
+}
@@ -11,10 +11,13 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"fmt"
 
+	"golang.org/x/crypto/curve25519"
 	"golang.org/x/crypto/nacl/box"
 )
 
@@ -34,6 +37,12 @@ type Encrypter struct {
 	Keypair    *Keypair
 	PeerPublic [32]byte
 	SharedKey  [32]byte
+	// Pad, if set, pads messages before sealing them, so that the length of
+	// the ciphertext doesn't reveal the exact length of the plaintext.
+	Pad bool
+	// Compress, if set, compresses messages before sealing them, whenever
+	// that makes them smaller.
+	Compress bool
 }
 
 // Decrypter is generated from a keypair (a fixed keypair, generally, whose
@@ -61,6 +70,13 @@ func (k *Keypair) Generate() (err error) {
 	return
 }
 
+// FromPrivate sets the Keypair's private key, and derives the corresponding
+// public key from it.
+func (k *Keypair) FromPrivate(priv [32]byte) {
+	k.Private = priv
+	curve25519.ScalarBaseMult(&k.Public, &k.Private)
+}
+
 // PublicString returns the public key in the canonical hex-encoded printable form.
 func (k *Keypair) PublicString() string {
 	return fmt.Sprintf("%x", k.Public)
@@ -104,13 +120,47 @@ func (e *Encrypter) encrypt(message []byte) (*boxedMessage, error) {
 	out := box.SealAfterPrecomputation(nil, []byte(message), &nonce, &e.SharedKey)
 
 	return &boxedMessage{
-		SchemaVersion:   1,
+		SchemaVersion:   schemaV1,
 		EncrypterPublic: e.Keypair.Public,
 		Nonce:           nonce,
 		Box:             out,
 	}, nil
 }
 
+// encryptWithFlags produces a schema version 2 message, sealing the flags
+// along with the message so they can be verified on decryption.
+func (e *Encrypter) encryptWithFlags(message []byte, flags string) (*boxedMessage, error) {
+	sealed := make([]byte, 0, len(flags)+1+len(message))
+	sealed = append(append(append(sealed, flags...), ':'), message...)
+	bm, err := e.encrypt(sealed)
+	if err != nil {
+		return nil, err
+	}
+	bm.SchemaVersion = schemaV2
+	bm.Flags = flags
+	return bm, nil
+}
+
+// seal encrypts a message with the given flags, applying the options set on
+// the Encrypter. Messages without flags use schema version 1, so that they
+// remain readable by older decrypters.
+func (e *Encrypter) seal(message []byte, flags string) (*boxedMessage, error) {
+	if e.Compress {
+		if compressed, ok := compress(message); ok {
+			message = compressed
+			flags += string(flagCompressed)
+		}
+	}
+	if e.Pad {
+		message = pad(message)
+		flags += string(flagPadded)
+	}
+	if flags == "" {
+		return e.encrypt(message)
+	}
+	return e.encryptWithFlags(message, flags)
+}
+
 // Encrypt takes a plaintext message and returns an encrypted message. Unlike
 // raw nacl/box encryption, this message is decryptable without passing the
 // nonce or public key out-of-band, as it includes both. This is not less
@@ -120,7 +170,22 @@ func (e *Encrypter) Encrypt(message []byte) ([]byte, error) {
 	if IsBoxedMessage(message) {
 		return message, nil
 	}
-	boxedMessage, err := e.encrypt(message)
+	boxedMessage, err := e.seal(message, "")
+	if err != nil {
+		return nil, err
+	}
+	return boxedMessage.Dump(), nil
+}
+
+// EncryptLiteral takes a JSON number, boolean or null literal and returns an
+// encrypted message which records the type of the literal, so that it can be
+// restored by DecryptValue.
+func (e *Encrypter) EncryptLiteral(literal []byte) ([]byte, error) {
+	flag, err := literalFlag(literal)
+	if err != nil {
+		return nil, err
+	}
+	boxedMessage, err := e.seal(literal, string(flag))
 	if err != nil {
 		return nil, err
 	}
@@ -132,11 +197,39 @@ func (e *Encrypter) Encrypt(message []byte) ([]byte, error) {
 // used to create the ciphertext. It returns the decrypted string. Note that,
 // unlike with encryption, Shared-key-precomputation is not used for decryption.
 func (d *Decrypter) Decrypt(message []byte) ([]byte, error) {
+	plaintext, _, err := d.DecryptValue(message)
+	return plaintext, err
+}
+
+// DecryptValue is like Decrypt, but also reports whether the plaintext is a
+// JSON number, boolean or null literal encrypted by EncryptLiteral, rather
+// than the contents of a string.
+func (d *Decrypter) DecryptValue(message []byte) (plaintext []byte, literal bool, err error) {
 	var bm boxedMessage
 	if err := bm.Load(message); err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	plaintext, err = d.decrypt(&bm)
+	if err != nil {
+		return nil, false, err
+	}
+	if bm.hasFlag(flagPadded) {
+		if plaintext, err = unpad(plaintext); err != nil {
+			return nil, false, ErrDecryptionFailed
+		}
+	}
+	if bm.hasFlag(flagCompressed) {
+		if plaintext, err = decompress(plaintext); err != nil {
+			return nil, false, err
+		}
+	}
+	literal = bm.hasFlag(flagNumber) || bm.hasFlag(flagBoolean) || bm.hasFlag(flagNull)
+	if literal {
+		if flag, err := literalFlag(plaintext); err != nil || !bm.hasFlag(flag) {
+			return nil, false, ErrDecryptionFailed
+		}
 	}
-	return d.decrypt(&bm)
+	return plaintext, literal, nil
 }
 
 func (d *Decrypter) decrypt(bm *boxedMessage) ([]byte, error) {
@@ -144,9 +237,33 @@ func (d *Decrypter) decrypt(bm *boxedMessage) ([]byte, error) {
 	if !ok {
 		return nil, ErrDecryptionFailed
 	}
+	if bm.SchemaVersion >= schemaV2 {
+		// The flags are sealed along with the message; make sure nobody has
+		// changed them since.
+		prefix := bm.Flags + ":"
+		if !bytes.HasPrefix(plaintext, []byte(prefix)) {
+			return nil, ErrDecryptionFailed
+		}
+		plaintext = plaintext[len(prefix):]
+	}
 	return plaintext, nil
 }
 
+// literalFlag returns the type flag for a JSON number, boolean or null
+// literal.
+func literalFlag(literal []byte) (byte, error) {
+	switch {
+	case !json.Valid(literal):
+	case bytes.Equal(literal, []byte("null")):
+		return flagNull, nil
+	case bytes.Equal(literal, []byte("true")), bytes.Equal(literal, []byte("false")):
+		return flagBoolean, nil
+	case literal[0] == '-' || (literal[0] >= '0' && literal[0] <= '9'):
+		return flagNumber, nil
+	}
+	return 0, errors.New("not a JSON number, boolean or null literal")
+}
+
 func genNonce() (nonce [24]byte, err error) {
 	var n int
 	n, err = rand.Read(nonce[0:24])
@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -26,6 +27,16 @@ func TestKeypairGeneration(t *testing.T) {
 	})
 }
 
+func TestKeypairFromPrivate(t *testing.T) {
+	Convey("Deriving a keypair from a private key", t, func() {
+		var generated, derived Keypair
+		So(generated.Generate(), ShouldBeNil)
+		derived.FromPrivate(generated.Private)
+		So(derived.Public, ShouldResemble, generated.Public)
+		So(derived.Private, ShouldResemble, generated.Private)
+	})
+}
+
 func TestNonceGeneration(t *testing.T) {
 	Convey("Generating a nonce", t, func() {
 		Convey("should be unique", func() {
@@ -63,24 +74,177 @@ func TestRoundtrip(t *testing.T) {
 	})
 }
 
-func ExampleEncrypt(peerPublic [32]byte) {
-	var kp Keypair
+func TestLiteralRoundtrip(t *testing.T) {
+	var kpEphemeral, kpSecret Keypair
+	kpEphemeral.Generate()
+	kpSecret.Generate()
+
+	Convey("Roundtripping literals", t, func() {
+		encrypter := kpEphemeral.Encrypter(kpSecret.Public)
+		decrypter := kpSecret.Decrypter()
+
+		for _, lit := range []string{"500", "-1.5e3", "true", "false", "null"} {
+			ct, err := encrypter.EncryptLiteral([]byte(lit))
+			So(err, ShouldBeNil)
+			So(string(ct), ShouldStartWith, "EJ[2:")
+			So(IsBoxedMessage(ct), ShouldBeTrue)
+			pt, literal, err := decrypter.DecryptValue(ct)
+			So(err, ShouldBeNil)
+			So(literal, ShouldBeTrue)
+			So(string(pt), ShouldEqual, lit)
+		}
+
+		Convey("rejects things that aren't literals", func() {
+			_, err := encrypter.EncryptLiteral([]byte(`"500"`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("strings are not reported as literals", func() {
+			ct, err := encrypter.Encrypt([]byte("500"))
+			So(err, ShouldBeNil)
+			pt, literal, err := decrypter.DecryptValue(ct)
+			So(err, ShouldBeNil)
+			So(literal, ShouldBeFalse)
+			So(string(pt), ShouldEqual, "500")
+		})
+
+		Convey("detects tampering with the type flag", func() {
+			ct, err := encrypter.EncryptLiteral([]byte("500"))
+			So(err, ShouldBeNil)
+			tampered := []byte(strings.Replace(string(ct), "EJ[2:n:", "EJ[2:b:", 1))
+			_, _, err = decrypter.DecryptValue(tampered)
+			So(err, ShouldEqual, ErrDecryptionFailed)
+		})
+	})
+}
+
+func TestPaddedRoundtrip(t *testing.T) {
+	var kpEphemeral, kpSecret Keypair
+	kpEphemeral.Generate()
+	kpSecret.Generate()
+
+	Convey("Roundtripping padded messages", t, func() {
+		encrypter := kpEphemeral.Encrypter(kpSecret.Public)
+		encrypter.Pad = true
+		decrypter := kpSecret.Decrypter()
+
+		Convey("hides the length of short messages", func() {
+			pin, err := encrypter.Encrypt([]byte("1234"))
+			So(err, ShouldBeNil)
+			So(string(pin), ShouldStartWith, "EJ[2:p:")
+			password, err := encrypter.Encrypt([]byte("correct horse battery staple"))
+			So(err, ShouldBeNil)
+			So(len(pin), ShouldEqual, len(password))
+
+			pt, err := decrypter.Decrypt(pin)
+			So(err, ShouldBeNil)
+			So(string(pt), ShouldEqual, "1234")
+		})
+
+		Convey("round trips messages of every length around a boundary", func() {
+			for n := 0; n <= 2*minPaddedSize+1; n++ {
+				message := []byte(strings.Repeat("\x80", n))
+				ct, err := encrypter.Encrypt(message)
+				So(err, ShouldBeNil)
+				pt, err := decrypter.Decrypt(ct)
+				So(err, ShouldBeNil)
+				So(string(pt), ShouldEqual, string(message))
+			}
+		})
+
+		Convey("pads literals too", func() {
+			ct, err := encrypter.EncryptLiteral([]byte("500"))
+			So(err, ShouldBeNil)
+			So(string(ct), ShouldStartWith, "EJ[2:np:")
+			pt, literal, err := decrypter.DecryptValue(ct)
+			So(err, ShouldBeNil)
+			So(literal, ShouldBeTrue)
+			So(string(pt), ShouldEqual, "500")
+		})
+
+		Convey("rejects invalid padding", func() {
+			_, err := unpad([]byte("abc\x80\x00\x01"))
+			So(err, ShouldNotBeNil)
+			_, err = unpad([]byte("abc\x00"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCompressedRoundtrip(t *testing.T) {
+	var kpEphemeral, kpSecret Keypair
+	kpEphemeral.Generate()
+	kpSecret.Generate()
+
+	Convey("Roundtripping compressed messages", t, func() {
+		encrypter := kpEphemeral.Encrypter(kpSecret.Public)
+		encrypter.Compress = true
+		decrypter := kpSecret.Decrypter()
+
+		Convey("compresses messages when that makes them smaller", func() {
+			message := []byte(strings.Repeat("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n", 50))
+			ct, err := encrypter.Encrypt(message)
+			So(err, ShouldBeNil)
+			So(string(ct), ShouldStartWith, "EJ[2:c:")
+			So(len(ct), ShouldBeLessThan, len(message))
+			pt, err := decrypter.Decrypt(ct)
+			So(err, ShouldBeNil)
+			So(string(pt), ShouldEqual, string(message))
+		})
+
+		Convey("leaves other messages alone", func() {
+			ct, err := encrypter.Encrypt([]byte("hunter2"))
+			So(err, ShouldBeNil)
+			So(string(ct), ShouldStartWith, "EJ[1:")
+		})
+
+		Convey("compresses before padding", func() {
+			encrypter.Pad = true
+			message := []byte(strings.Repeat("a", 1000))
+			ct, err := encrypter.Encrypt(message)
+			So(err, ShouldBeNil)
+			So(string(ct), ShouldStartWith, "EJ[2:cp:")
+			pt, err := decrypter.Decrypt(ct)
+			So(err, ShouldBeNil)
+			So(string(pt), ShouldEqual, string(message))
+		})
+
+		Convey("refuses to decompress too much", func() {
+			bomb, ok := compress(make([]byte, maxDecompressedSize+1))
+			So(ok, ShouldBeTrue)
+			bm, err := encrypter.encryptWithFlags(bomb, string(flagCompressed))
+			So(err, ShouldBeNil)
+			_, err = decrypter.Decrypt(bm.Dump())
+			So(err, ShouldEqual, ErrValueTooLarge)
+		})
+	})
+}
+
+func ExampleEncrypter_Encrypt() {
+	var kp, peer Keypair
 	if err := kp.Generate(); err != nil {
 		panic(err)
 	}
+	if err := peer.Generate(); err != nil {
+		panic(err)
+	}
 
-	encrypter := kp.Encrypter(peerPublic)
+	encrypter := kp.Encrypter(peer.Public)
 	boxed, err := encrypter.Encrypt([]byte("this is my message"))
-	fmt.Println(boxed, err)
+	fmt.Println(string(boxed), err)
 }
 
-func ExampleDecrypt(myPublic, myPrivate [32]byte, encrypted []byte) {
-	kp := Keypair{
-		Public:  myPublic,
-		Private: myPrivate,
+func ExampleDecrypter_Decrypt() {
+	var kp Keypair
+	if err := kp.Generate(); err != nil {
+		panic(err)
+	}
+	encrypted, err := kp.Encrypter(kp.Public).Encrypt([]byte("this is my message"))
+	if err != nil {
+		panic(err)
 	}
 
 	decrypter := kp.Decrypter()
 	plaintext, err := decrypter.Decrypt(encrypted)
-	fmt.Println(plaintext, err)
+	fmt.Println(string(plaintext), err)
 }
@@ -0,0 +1,40 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// MessageInfo describes an encrypted message, as far as that is possible
+// without decrypting it.
+type MessageInfo struct {
+	SchemaVersion int
+	// Flags are the feature flags of a schema version 2 message.
+	Flags string
+	// EncrypterPublic is the public key of the ephemeral keypair that
+	// encrypted the message. Values encrypted in the same run share one.
+	EncrypterPublic [32]byte
+	// Length is the length of the sealed box, in bytes.
+	Length int
+}
+
+// InspectMessage parses an encrypted message in the boxedMessage wire format
+// and describes it.
+func InspectMessage(message []byte) (MessageInfo, error) {
+	var bm boxedMessage
+	if err := bm.Load(message); err != nil {
+		return MessageInfo{}, err
+	}
+	return MessageInfo{
+		SchemaVersion:   bm.SchemaVersion,
+		Flags:           bm.Flags,
+		EncrypterPublic: bm.EncrypterPublic,
+		Length:          len(bm.Box),
+	}, nil
+}
+
+// Fingerprint returns a short hex-encoded hash of the encrypter's public key.
+func (m MessageInfo) Fingerprint() string {
+	sum := sha256.Sum256(m.EncrypterPublic[:])
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,40 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+)
+
+// minPaddedSize is the smallest size a padded message is padded to, so that
+// all short secrets look alike.
+const minPaddedSize = 32
+
+var errBadPadding = errors.New("invalid padding")
+
+// pad hides the length of a message by appending a 0x80 byte and then zeros,
+// up to the next power of two (but at least minPaddedSize). Which power of
+// two still shows the rough magnitude of the length, but no more than that.
+func pad(message []byte) []byte {
+	size := minPaddedSize
+	for size < len(message)+1 {
+		size *= 2
+	}
+	padded := make([]byte, size)
+	copy(padded, message)
+	padded[len(message)] = 0x80
+	return padded
+}
+
+// unpad reverses pad.
+func unpad(padded []byte) ([]byte, error) {
+	end := bytes.LastIndexByte(padded, 0x80)
+	if end < 0 {
+		return nil, errBadPadding
+	}
+	for _, b := range padded[end+1:] {
+		if b != 0 {
+			return nil, errBadPadding
+		}
+	}
+	return padded[:end], nil
+}
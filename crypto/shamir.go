@@ -0,0 +1,114 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Share is one share of a secret split by SplitSecret. Y holds one byte for
+// each byte of the secret: the value at X of the polynomial that byte was the
+// constant term of.
+type Share struct {
+	X byte
+	Y []byte
+}
+
+// ErrInvalidShares means that a set of shares can't be combined: they're of
+// different lengths, or two have the same X.
+var ErrInvalidShares = errors.New("invalid set of shares")
+
+// SplitSecret splits secret into n shares using Shamir's Secret Sharing over
+// GF(2^8), such that any threshold of them reconstruct the secret with
+// CombineSecret, and fewer reveal nothing about it.
+func SplitSecret(secret []byte, n, threshold int) ([]Share, error) {
+	switch {
+	case threshold < 2:
+		return nil, fmt.Errorf("threshold must be at least 2, not %d", threshold)
+	case n < threshold:
+		return nil, fmt.Errorf("can't make %d shares with a threshold of %d", n, threshold)
+	case n > 255:
+		return nil, fmt.Errorf("can't make more than 255 shares, not %d", n)
+	}
+
+	shares := make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{X: byte(i + 1), Y: make([]byte, len(secret))}
+	}
+	coefficients := make([]byte, threshold)
+	defer clear(coefficients)
+	for b, s := range secret {
+		coefficients[0] = s
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			// Horner's method, from the highest coefficient down.
+			var y byte
+			for c := threshold - 1; c >= 0; c-- {
+				y = gfMul(y, shares[i].X) ^ coefficients[c]
+			}
+			shares[i].Y[b] = y
+		}
+	}
+	return shares, nil
+}
+
+// CombineSecret reconstructs a secret from shares made by SplitSecret. Given
+// fewer shares than the threshold the secret was split with, it returns a
+// value unrelated to the secret, so the result must be checked.
+func CombineSecret(shares []Share) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("%w: at least 2 shares are needed", ErrInvalidShares)
+	}
+	size := len(shares[0].Y)
+	for i, share := range shares {
+		if share.X == 0 || len(share.Y) != size {
+			return nil, ErrInvalidShares
+		}
+		for _, other := range shares[:i] {
+			if other.X == share.X {
+				return nil, fmt.Errorf("%w: share %d is repeated", ErrInvalidShares, share.X)
+			}
+		}
+	}
+
+	// Lagrange interpolation at x = 0. In GF(2^8), subtraction is addition is
+	// xor.
+	secret := make([]byte, size)
+	for i, share := range shares {
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				basis = gfMul(basis, gfDiv(other.X, other.X^share.X))
+			}
+		}
+		for b, y := range share.Y {
+			secret[b] ^= gfMul(y, basis)
+		}
+	}
+	return secret, nil
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial, x^8 + x^4 + x^3 + x +
+// 1, without branching on its operands.
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= a & -(b & 1)
+		carry := -(a >> 7)
+		a = a<<1 ^ 0x1b&carry
+		b >>= 1
+	}
+	return p
+}
+
+// gfDiv divides a by b, which must not be 0, as a times the inverse of b,
+// b^254.
+func gfDiv(a, b byte) byte {
+	inv := b
+	for i := 0; i < 6; i++ {
+		inv = gfMul(gfMul(inv, inv), b)
+	}
+	return gfMul(a, gfMul(inv, inv))
+}
@@ -0,0 +1,68 @@
+package crypto
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGaloisField(t *testing.T) {
+	Convey("GF(2^8) arithmetic", t, func() {
+		So(gfMul(0x53, 0xca), ShouldEqual, 0x01)
+		So(gfMul(0x57, 0x83), ShouldEqual, 0xc1)
+		for a := 1; a < 256; a++ {
+			So(gfDiv(byte(a), byte(a)), ShouldEqual, 1)
+			So(gfMul(gfDiv(1, byte(a)), byte(a)), ShouldEqual, 1)
+		}
+	})
+}
+
+func TestShamir(t *testing.T) {
+	Convey("Splitting a secret", t, func() {
+		var kp Keypair
+		So(kp.Generate(), ShouldBeNil)
+		shares, err := SplitSecret(kp.Private[:], 5, 3)
+		So(err, ShouldBeNil)
+		So(shares, ShouldHaveLength, 5)
+
+		Convey("any threshold of the shares should reconstruct it", func() {
+			for i := 0; i < 5; i++ {
+				for j := i + 1; j < 5; j++ {
+					for k := j + 1; k < 5; k++ {
+						secret, err := CombineSecret([]Share{shares[k], shares[i], shares[j]})
+						So(err, ShouldBeNil)
+						So(secret, ShouldResemble, kp.Private[:])
+					}
+				}
+			}
+			secret, err := CombineSecret(shares)
+			So(err, ShouldBeNil)
+			So(secret, ShouldResemble, kp.Private[:])
+		})
+
+		Convey("fewer shares should not", func() {
+			secret, err := CombineSecret(shares[:2])
+			So(err, ShouldBeNil)
+			So(secret, ShouldNotResemble, kp.Private[:])
+		})
+
+		Convey("invalid sets of shares should be rejected", func() {
+			_, err := CombineSecret([]Share{shares[0], shares[0], shares[1]})
+			So(errors.Is(err, ErrInvalidShares), ShouldBeTrue)
+			_, err = CombineSecret([]Share{shares[0], {X: 2, Y: []byte{1}}})
+			So(errors.Is(err, ErrInvalidShares), ShouldBeTrue)
+			_, err = CombineSecret(shares[:1])
+			So(errors.Is(err, ErrInvalidShares), ShouldBeTrue)
+		})
+
+		Convey("invalid parameters should be rejected", func() {
+			_, err := SplitSecret(kp.Private[:], 5, 1)
+			So(err, ShouldNotBeNil)
+			_, err = SplitSecret(kp.Private[:], 2, 3)
+			So(err, ShouldNotBeNil)
+			_, err = SplitSecret(kp.Private[:], 256, 3)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,52 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidSigningKey means that a key meant for signing or verifying
+// documents was neither hex-encoded nor an ed25519 SSH key.
+var ErrInvalidSigningKey = errors.New("invalid signing key")
+
+// ParseSigningKey parses an ed25519 key for signing documents: either its
+// 32-byte seed, hex-encoded, or an OpenSSH ed25519 private key, which is
+// decrypted with the passphrase if it isn't nil.
+func ParseSigningKey(data, passphrase []byte) (ed25519.PrivateKey, error) {
+	if bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+		return parseSSHPrivateKey(data, passphrase)
+	}
+	seed, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, ErrInvalidSigningKey
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ParseVerifyingKeys parses ed25519 public keys for verifying signatures, one
+// per line: either hex-encoded, or in the format of an authorized_keys file.
+// Blank lines and lines beginning with '#' are ignored.
+func ParseVerifyingKeys(data []byte) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if key, err := hex.DecodeString(string(line)); err == nil && len(key) == ed25519.PublicKeySize {
+			keys = append(keys, ed25519.PublicKey(key))
+			continue
+		}
+		key, _, err := parseSSHPublicKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w on line %d: %w", ErrInvalidSigningKey, n, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
+}
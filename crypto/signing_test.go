@@ -0,0 +1,56 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSigningKeys(t *testing.T) {
+	Convey("Signing keys", t, func() {
+		edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+		So(err, ShouldBeNil)
+
+		Convey("should be read from a hex seed", func() {
+			key, err := ParseSigningKey([]byte(hex.EncodeToString(edPriv.Seed())+"\n"), nil)
+			So(err, ShouldBeNil)
+			So(key, ShouldResemble, edPriv)
+		})
+
+		Convey("should be read from an SSH key", func() {
+			block, err := ssh.MarshalPrivateKey(edPriv, "")
+			So(err, ShouldBeNil)
+			key, err := ParseSigningKey(pem.EncodeToMemory(block), nil)
+			So(err, ShouldBeNil)
+			So(key, ShouldResemble, edPriv)
+		})
+
+		Convey("should reject anything else", func() {
+			_, err := ParseSigningKey([]byte("abcd"), nil)
+			So(errors.Is(err, ErrInvalidSigningKey), ShouldBeTrue)
+		})
+
+		Convey("should read verifying keys in either format", func() {
+			sshPub, err := ssh.NewPublicKey(edPub)
+			So(err, ShouldBeNil)
+			var data bytes.Buffer
+			data.WriteString("# release managers\n\n")
+			data.WriteString(hex.EncodeToString(edPub) + "\n")
+			data.Write(ssh.MarshalAuthorizedKey(sshPub))
+
+			keys, err := ParseVerifyingKeys(data.Bytes())
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []ed25519.PublicKey{edPub, edPub})
+
+			_, err = ParseVerifyingKeys(append(data.Bytes(), "nonsense\n"...))
+			So(errors.Is(err, ErrInvalidSigningKey), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,130 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrUnsupportedSSHKey means that an SSH key is not an ed25519 key, the only
+// type that can be converted to a Curve25519 key.
+var ErrUnsupportedSSHKey = errors.New("only ed25519 SSH keys are supported")
+
+// FromSSHPrivateKey sets the Keypair from an OpenSSH ed25519 private key, as
+// found in ~/.ssh/id_ed25519. The ed25519 key is converted to the X25519 key
+// that shares its secret scalar, as in RFC 8032 and libsodium's
+// crypto_sign_ed25519_sk_to_curve25519, so the public key matches the one
+// SSHPublicKey derives from the corresponding public key. The passphrase is
+// only used if the key is encrypted, and may be nil otherwise.
+func (k *Keypair) FromSSHPrivateKey(pemBytes, passphrase []byte) error {
+	edPriv, err := parseSSHPrivateKey(pemBytes, passphrase)
+	if err != nil {
+		return err
+	}
+
+	h := sha512.Sum512(edPriv.Seed())
+	var priv [32]byte
+	copy(priv[:], h[:32])
+	priv[0] &= 248
+	priv[31] &= 127
+	priv[31] |= 64
+	k.FromPrivate(priv)
+	return nil
+}
+
+// SSHPublicKey converts an ed25519 SSH public key, in the format used by
+// authorized_keys files and id_ed25519.pub, to the Curve25519 public key of
+// the Keypair FromSSHPrivateKey derives from the matching private key. It
+// also returns the comment from the key line, if there is one.
+func SSHPublicKey(line []byte) (pub [32]byte, comment string, err error) {
+	edPub, comment, err := parseSSHPublicKey(line)
+	if err != nil {
+		return pub, "", err
+	}
+	pub, err = edwardsToMontgomery(edPub)
+	return pub, comment, err
+}
+
+// parseSSHPrivateKey parses an OpenSSH ed25519 private key, decrypting it
+// with the passphrase if it isn't nil.
+func parseSSHPrivateKey(pemBytes, passphrase []byte) (ed25519.PrivateKey, error) {
+	var (
+		raw interface{}
+		err error
+	)
+	if passphrase != nil {
+		raw, err = ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+	} else {
+		raw, err = ssh.ParseRawPrivateKey(pemBytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := raw.(type) {
+	case ed25519.PrivateKey:
+		return key, nil
+	case *ed25519.PrivateKey:
+		return *key, nil
+	default:
+		return nil, fmt.Errorf("%w, not %T", ErrUnsupportedSSHKey, raw)
+	}
+}
+
+// parseSSHPublicKey parses an ed25519 SSH public key from a line of an
+// authorized_keys file, returning the key and its comment.
+func parseSSHPublicKey(line []byte) (ed25519.PublicKey, string, error) {
+	sshPub, comment, _, _, err := ssh.ParseAuthorizedKey(line)
+	if err != nil {
+		return nil, "", err
+	}
+	if sshPub.Type() != ssh.KeyAlgoED25519 {
+		return nil, "", fmt.Errorf("%w, not %s", ErrUnsupportedSSHKey, sshPub.Type())
+	}
+	edPub, ok := sshPub.(ssh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, "", ErrUnsupportedSSHKey
+	}
+	return edPub, comment, nil
+}
+
+// curve25519P is the field prime, 2^255 - 19.
+var curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// edwardsToMontgomery maps an encoded Edwards25519 point to the u-coordinate
+// of the birationally equivalent Curve25519 point, u = (1 + y) / (1 - y).
+func edwardsToMontgomery(edPub ed25519.PublicKey) (u [32]byte, err error) {
+	if len(edPub) != ed25519.PublicKeySize {
+		return u, ErrUnsupportedSSHKey
+	}
+	// The encoding is y in little-endian order, with the sign of x in the top
+	// bit, which the mapping doesn't need.
+	var be [32]byte
+	for i, b := range edPub {
+		be[31-i] = b
+	}
+	be[0] &= 0x7f
+	y := new(big.Int).SetBytes(be[:])
+	if y.Cmp(curve25519P) >= 0 {
+		return u, fmt.Errorf("%w: invalid ed25519 public key", ErrUnsupportedSSHKey)
+	}
+
+	num := new(big.Int).Add(big.NewInt(1), y)
+	den := new(big.Int).Sub(big.NewInt(1), y)
+	den.Mod(den, curve25519P)
+	if den.Sign() == 0 {
+		return u, fmt.Errorf("%w: invalid ed25519 public key", ErrUnsupportedSSHKey)
+	}
+	den.ModInverse(den, curve25519P)
+	num.Mul(num, den).Mod(num, curve25519P)
+
+	num.FillBytes(be[:])
+	for i, b := range be {
+		u[31-i] = b
+	}
+	return u, nil
+}
@@ -0,0 +1,86 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHKeys(t *testing.T) {
+	Convey("Converting SSH keys", t, func() {
+		edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+		So(err, ShouldBeNil)
+		sshPub, err := ssh.NewPublicKey(edPub)
+		So(err, ShouldBeNil)
+		authorizedKey := bytes.TrimSuffix(ssh.MarshalAuthorizedKey(sshPub), []byte("\n"))
+		authorizedKey = append(authorizedKey, " alice@example.com\n"...)
+
+		block, err := ssh.MarshalPrivateKey(edPriv, "alice@example.com")
+		So(err, ShouldBeNil)
+		privPEM := pem.EncodeToMemory(block)
+
+		Convey("should derive the same public key from both halves", func() {
+			var kp Keypair
+			So(kp.FromSSHPrivateKey(privPEM, nil), ShouldBeNil)
+
+			pub, comment, err := SSHPublicKey(authorizedKey)
+			So(err, ShouldBeNil)
+			So(comment, ShouldEqual, "alice@example.com")
+			So(pub, ShouldResemble, kp.Public)
+
+			Convey("which can be used to encrypt to the private key", func() {
+				var ephemeral Keypair
+				So(ephemeral.Generate(), ShouldBeNil)
+				boxed, err := ephemeral.Encrypter(pub).Encrypt([]byte("secret"))
+				So(err, ShouldBeNil)
+				plaintext, err := kp.Decrypter().Decrypt(boxed)
+				So(err, ShouldBeNil)
+				So(string(plaintext), ShouldEqual, "secret")
+			})
+		})
+
+		Convey("should read passphrase-protected private keys", func() {
+			block, err := ssh.MarshalPrivateKeyWithPassphrase(edPriv, "", []byte("hunter2"))
+			So(err, ShouldBeNil)
+			encrypted := pem.EncodeToMemory(block)
+
+			var kp, plain Keypair
+			So(plain.FromSSHPrivateKey(privPEM, nil), ShouldBeNil)
+
+			var missing *ssh.PassphraseMissingError
+			So(errors.As(kp.FromSSHPrivateKey(encrypted, nil), &missing), ShouldBeTrue)
+			So(kp.FromSSHPrivateKey(encrypted, []byte("wrong")), ShouldNotBeNil)
+			So(kp.FromSSHPrivateKey(encrypted, []byte("hunter2")), ShouldBeNil)
+			So(kp, ShouldResemble, plain)
+		})
+
+		Convey("should reject other key types", func() {
+			ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			So(err, ShouldBeNil)
+			ecPub, err := ssh.NewPublicKey(&ecPriv.PublicKey)
+			So(err, ShouldBeNil)
+			_, _, err = SSHPublicKey(ssh.MarshalAuthorizedKey(ecPub))
+			So(errors.Is(err, ErrUnsupportedSSHKey), ShouldBeTrue)
+
+			block, err := ssh.MarshalPrivateKey(ecPriv, "")
+			So(err, ShouldBeNil)
+			var kp Keypair
+			So(errors.Is(kp.FromSSHPrivateKey(pem.EncodeToMemory(block), nil), ErrUnsupportedSSHKey), ShouldBeTrue)
+		})
+
+		Convey("should reject malformed keys", func() {
+			var kp Keypair
+			So(kp.FromSSHPrivateKey([]byte("not a key"), nil), ShouldNotBeNil)
+			_, _, err := SSHPublicKey([]byte("ssh-ed25519 AAAA"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
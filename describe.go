@@ -0,0 +1,171 @@
+package ejson
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/Shopify/ejson/crypto"
+	"github.com/Shopify/ejson/json"
+)
+
+// DescribeOptions changes what Describe includes in its descriptions.
+type DescribeOptions struct {
+	// HashKey, if set, adds an HMAC-SHA256 of each decrypted value, keyed
+	// with HashKey, so that equal values can be spotted. Descriptions made
+	// with the same HashKey can be compared, even across documents. This
+	// needs the private key, from Keydir or UserSuppliedPrivateKey.
+	HashKey                []byte
+	Keydir                 string
+	UserSuppliedPrivateKey string
+	// Audit, if not nil, is called with an AuditEvent after the document is
+	// decrypted to hash its values, as in DecryptOptions.Audit.
+	Audit AuditFunc
+}
+
+// Describe reads an EJSON document from 'in' and writes a redacted version of
+// it to 'out', with every encrypted value replaced by a description of its
+// ciphertext: its schema version and flags, its length, and a fingerprint of
+// the key that encrypted it. Values that should have been encrypted but
+// weren't are redacted too. Unless opts.HashKey is set, no private key is
+// needed.
+func Describe(in io.Reader, out io.Writer, opts DescribeOptions) error {
+	return describe(in, out, opts, "")
+}
+
+// describe implements Describe, giving file as the path of the document in
+// audit events.
+func describe(in io.Reader, out io.Writer, opts DescribeOptions, file string) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	data, err = json.CollapseMultilineStringLiterals(data)
+	if err != nil {
+		return err
+	}
+
+	selectLiteral, err := json.ExtractLiteralSelector(data)
+	if err != nil {
+		return err
+	}
+
+	if opts.HashKey == nil {
+		newdata, err := describeDocument(data, nil, nil, selectLiteral, nil)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(newdata)
+		return err
+	}
+
+	var event *AuditEvent
+	if opts.Audit != nil {
+		e := NewAuditEvent(file)
+		event = &e
+	}
+	newdata, err := describeDecrypted(data, opts, selectLiteral, event)
+	if event != nil {
+		err = recordAudit(opts.Audit, *event, err)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(newdata)
+	return err
+}
+
+// describeDecrypted describes a document, decrypting its values to hash
+// them. If event is not nil, the document's public key and the paths of the
+// values decrypted are recorded in it.
+func describeDecrypted(data []byte, opts DescribeOptions, selectLiteral json.LiteralSelector, event *AuditEvent) ([]byte, error) {
+	pubkey, err := json.ExtractPublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	if event != nil {
+		event.PublicKey = fmt.Sprintf("%x", pubkey)
+	}
+	privkey, err := findPrivateKey(pubkey, opts.Keydir, opts.UserSuppliedPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	var kp crypto.Keypair
+	kp.FromPrivate(privkey)
+	return describeDocument(data, kp.Decrypter(), opts.HashKey, selectLiteral, event)
+}
+
+// describeDocument replaces each value in a document with its description.
+// If event is not nil, the paths of the values decrypted are recorded in it.
+func describeDocument(data []byte, decrypter *crypto.Decrypter, hashKey []byte, selectLiteral json.LiteralSelector, event *AuditEvent) ([]byte, error) {
+	var mu sync.Mutex
+	walker := json.Walker{
+		ValueAction: func(v json.Value) (json.Value, error) {
+			if v.Literal && !selectLiteral(v.Path) {
+				return v, nil
+			}
+			description, err := describeValue(v, decrypter, hashKey)
+			if err == nil && event != nil && !v.Literal && crypto.IsBoxedMessage(v.Data) {
+				mu.Lock()
+				event.Paths = append(event.Paths, v.Path)
+				mu.Unlock()
+			}
+			return json.Value{Path: v.Path, Data: []byte(description)}, err
+		},
+	}
+	return walker.Walk(data)
+}
+
+// DescribeFile is like Describe, but reads the document from the file at
+// filePath and returns the description.
+func DescribeFile(filePath string, opts DescribeOptions) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var outBuffer bytes.Buffer
+	err = describe(file, &outBuffer, opts, filePath)
+	return outBuffer.Bytes(), err
+}
+
+// describeValue returns the description Describe replaces a value with. If
+// decrypter is not nil, it includes the keyed hash of the plaintext.
+func describeValue(v json.Value, decrypter *crypto.Decrypter, hashKey []byte) (string, error) {
+	if v.Literal || !crypto.IsBoxedMessage(v.Data) {
+		return "<not encrypted>", nil
+	}
+	info, err := crypto.InspectMessage(v.Data)
+	if err != nil {
+		return fmt.Sprintf("<invalid ciphertext: %s>", err), nil
+	}
+
+	description := fmt.Sprintf("<encrypted v%d", info.SchemaVersion)
+	if info.Flags != "" {
+		description += fmt.Sprintf(" (%s)", info.Flags)
+	}
+	description += fmt.Sprintf(", %d bytes, encrypter %s", info.Length, info.Fingerprint())
+	if decrypter != nil {
+		plaintext, literal, err := decrypter.DecryptValue(v.Data)
+		if err != nil {
+			return "", err
+		}
+		// Hash the type along with the plaintext, so that "500" and 500 differ.
+		mac := hmac.New(sha256.New, hashKey)
+		if literal {
+			mac.Write([]byte{'l'})
+		} else {
+			mac.Write([]byte{'s'})
+		}
+		mac.Write(plaintext)
+		description += ", hmac " + hex.EncodeToString(mac.Sum(nil)[:8])
+	}
+	return description + ">", nil
+}
@@ -0,0 +1,45 @@
+package ejson
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDescribe(t *testing.T) {
+	Convey("Describe", t, func() {
+		var encrypted bytes.Buffer
+		_, err := EncryptWithOptions(strings.NewReader(`{"_public_key": "`+validPubKey+`", "_encrypt_literals": ["port"], "a": "secret", "b": "secret", "c": "other", "port": 5432, "_note": "hi"}`), &encrypted, EncryptOptions{Pad: true})
+		So(err, ShouldBeNil)
+
+		Convey("should redact every encrypted value without a private key", func() {
+			var out bytes.Buffer
+			So(Describe(bytes.NewReader(encrypted.Bytes()), &out, DescribeOptions{}), ShouldBeNil)
+			So(out.String(), ShouldNotContainSubstring, "EJ[")
+			So(out.String(), ShouldContainSubstring, `"_note": "hi"`)
+			So(out.String(), ShouldContainSubstring, `"port": "<encrypted v2 (np), 51 bytes, encrypter `)
+			So(out.String(), ShouldNotContainSubstring, "hmac")
+			So(regexp.MustCompile(`"a": "<encrypted v2 \(p\), 50 bytes, encrypter [0-9a-f]{8}>"`).MatchString(out.String()), ShouldBeTrue)
+		})
+
+		Convey("should redact values that aren't encrypted", func() {
+			var out bytes.Buffer
+			So(Describe(strings.NewReader(`{"_public_key": "`+validPubKey+`", "a": "secret"}`), &out, DescribeOptions{}), ShouldBeNil)
+			So(out.String(), ShouldEqual, `{"_public_key": "`+validPubKey+`", "a": "<not encrypted>"}`)
+		})
+
+		Convey("should add keyed hashes that match for equal values", func() {
+			hmacOf := func(hashKey, key string) string {
+				var out bytes.Buffer
+				So(Describe(bytes.NewReader(encrypted.Bytes()), &out, DescribeOptions{HashKey: []byte(hashKey), UserSuppliedPrivateKey: validPrivKey}), ShouldBeNil)
+				return regexp.MustCompile(`"` + key + `": "[^"]*hmac ([0-9a-f]{16})>"`).FindStringSubmatch(out.String())[1]
+			}
+			So(hmacOf("k1", "a"), ShouldEqual, hmacOf("k1", "b"))
+			So(hmacOf("k1", "a"), ShouldNotEqual, hmacOf("k1", "c"))
+			So(hmacOf("k1", "a"), ShouldNotEqual, hmacOf("k2", "a"))
+		})
+	})
+}
@@ -0,0 +1,278 @@
+package ejson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Shopify/ejson/crypto"
+	ejsonjson "github.com/Shopify/ejson/json"
+)
+
+// ChangeKind classifies a Change.
+type ChangeKind int
+
+const (
+	// Added means the path is only in the second document.
+	Added ChangeKind = iota
+	// Removed means the path is only in the first document.
+	Removed
+	// Changed means the value at the path differs.
+	Changed
+	// PossiblyChanged means the value at the path is encrypted differently,
+	// and without the private key there's no telling whether the plaintext
+	// changed too.
+	PossiblyChanged
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Changed:
+		return "changed"
+	case PossiblyChanged:
+		return "possibly changed"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is a difference between two documents, found by Diff.
+type Change struct {
+	Path string
+	Kind ChangeKind
+	// Old and New are the JSON text of the value in each document, decrypted
+	// if DiffOptions.Values was set, the value was encrypted and the private
+	// key was available. Old is nil for additions and New is nil for
+	// removals.
+	Old, New []byte
+}
+
+// DiffOptions says where Diff can find the private keys of the documents it
+// compares. If neither is given, or the keydir has no key for a document, its
+// encrypted values aren't decrypted.
+type DiffOptions struct {
+	Keydir                 string
+	UserSuppliedPrivateKey string
+	// Values asks for the plaintexts of the values in each Change. Without
+	// it, only values that differ in both documents are decrypted, to compare
+	// them, and the values in each Change are left as they are.
+	Values bool
+	// Audit, if not nil, is called with an AuditEvent for each document
+	// whose private key was found, as in DecryptOptions.Audit. Files names
+	// the documents in those events, in the order they are given.
+	Audit AuditFunc
+	Files []string
+}
+
+// file returns the name of the i-th document, for audit events.
+func (o DiffOptions) file(i int) string {
+	if i < len(o.Files) {
+		return o.Files[i]
+	}
+	return ""
+}
+
+// diffSide is one document being compared by Diff, or merged by
+// MergeDocuments.
+type diffSide struct {
+	data      []byte
+	leaves    []ejsonjson.Leaf
+	byPath    map[string][]byte
+	decrypter *crypto.Decrypter
+	// event, if auditing, records the decryption of the document's values,
+	// whose ciphertexts are collected in decrypted.
+	event     *AuditEvent
+	decrypted map[string]bool
+}
+
+// Diff compares two EJSON documents, listing the paths whose values were
+// added, removed or changed. Paths are those of the documents' leaves (see
+// json.Leaves), in the order they appear in a, followed by those only in b.
+// Since encrypting a value again changes its ciphertext, an encrypted value
+// that differs is reported as PossiblyChanged unless both documents can be
+// decrypted, in which case the plaintexts are compared. The documents may use
+// different public keys.
+func Diff(a, b io.Reader, opts DiffOptions) ([]Change, error) {
+	before, err := loadDiffSide(a, opts, 0)
+	if err != nil {
+		return nil, err
+	}
+	after, err := loadDiffSide(b, opts, 1)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := diffSides(before, after, opts.Values)
+	if err := auditDiffSides(opts.Audit, err, before, after); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// diffSides compares two loaded documents, as Diff does. Values are only
+// decrypted to be reported if values is set.
+func diffSides(before, after *diffSide, values bool) ([]Change, error) {
+	var changes []Change
+	for _, leaf := range before.leaves {
+		value, ok := after.byPath[leaf.Path]
+		if !ok {
+			oldValue, err := before.reported(leaf.Value, values)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, Change{Path: leaf.Path, Kind: Removed, Old: oldValue})
+			continue
+		}
+		if bytes.Equal(leaf.Value, value) {
+			continue
+		}
+		oldValue, oldKnown, err := before.plaintext(leaf.Value)
+		if err != nil {
+			return nil, err
+		}
+		newValue, newKnown, err := after.plaintext(value)
+		if err != nil {
+			return nil, err
+		}
+		change := Change{Path: leaf.Path, Old: leaf.Value, New: value}
+		if values {
+			change.Old, change.New = oldValue, newValue
+		}
+		switch {
+		case !oldKnown || !newKnown:
+			change.Kind = PossiblyChanged
+		case !bytes.Equal(oldValue, newValue):
+			change.Kind = Changed
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	for _, leaf := range after.leaves {
+		if _, ok := before.byPath[leaf.Path]; ok {
+			continue
+		}
+		newValue, err := after.reported(leaf.Value, values)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, Change{Path: leaf.Path, Kind: Added, New: newValue})
+	}
+	return changes, nil
+}
+
+// loadDiffSide loads the i-th document given to Diff or MergeDocuments.
+func loadDiffSide(in io.Reader, opts DiffOptions, i int) (*diffSide, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = ejsonjson.CollapseMultilineStringLiterals(data)
+	if err != nil {
+		return nil, err
+	}
+
+	side := &diffSide{data: data, byPath: make(map[string][]byte)}
+	if side.leaves, err = ejsonjson.Leaves(data); err != nil {
+		return nil, err
+	}
+	for _, leaf := range side.leaves {
+		side.byPath[leaf.Path] = leaf.Value
+	}
+
+	if opts.Keydir == "" && opts.UserSuppliedPrivateKey == "" {
+		return side, nil
+	}
+	pubkey, err := ejsonjson.ExtractPublicKey(data)
+	if errors.Is(err, ejsonjson.ErrPublicKeyMissing) {
+		// Nothing in the document can be encrypted, either.
+		return side, nil
+	} else if err != nil {
+		return nil, err
+	}
+	privkey, err := findPrivateKey(pubkey, opts.Keydir, opts.UserSuppliedPrivateKey)
+	if errors.Is(err, ErrKeyNotFound) {
+		return side, nil
+	}
+	if opts.Audit != nil {
+		event := NewAuditEvent("")
+		event.File = opts.file(i)
+		event.PublicKey = fmt.Sprintf("%x", pubkey)
+		if err != nil {
+			return nil, recordAudit(opts.Audit, event, err)
+		}
+		side.event = &event
+		side.decrypted = make(map[string]bool)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var kp crypto.Keypair
+	kp.FromPrivate(privkey)
+	side.decrypter = kp.Decrypter()
+	return side, nil
+}
+
+// auditDiffSides records the decryption of each of sides that was audited,
+// given err, the result of comparing or merging them. It returns err, or an
+// error recording an event.
+func auditDiffSides(audit AuditFunc, err error, sides ...*diffSide) error {
+	for _, side := range sides {
+		if side.event == nil {
+			continue
+		}
+		for _, leaf := range side.leaves {
+			if side.decrypted[string(leaf.Value)] {
+				side.event.Paths = append(side.event.Paths, leaf.Path)
+			}
+		}
+		if auditErr := recordAudit(audit, *side.event, err); err == nil {
+			err = auditErr
+		}
+	}
+	return err
+}
+
+// reported returns the text of a leaf's value to report in a Change: its
+// plaintext, if values are wanted, or else the value as it is.
+func (s *diffSide) reported(value []byte, values bool) ([]byte, error) {
+	if !values {
+		return value, nil
+	}
+	plaintext, _, err := s.plaintext(value)
+	return plaintext, err
+}
+
+// plaintext returns the canonical JSON text of the plaintext of a leaf, and
+// whether it is known: if the leaf is encrypted and can't be decrypted, its
+// value is returned as it is, and isn't known.
+func (s *diffSide) plaintext(value []byte) (plaintext []byte, known bool, err error) {
+	var str string
+	if json.Unmarshal(value, &str) != nil || !crypto.IsBoxedMessage([]byte(str)) {
+		return value, true, nil
+	}
+	if s.decrypter == nil {
+		return value, false, nil
+	}
+
+	if s.decrypted != nil {
+		s.decrypted[string(value)] = true
+	}
+	decrypted, literal, err := s.decrypter.DecryptValue([]byte(str))
+	if err != nil {
+		return nil, false, err
+	}
+	if !literal {
+		if decrypted, err = json.Marshal(string(decrypted)); err != nil {
+			return nil, false, err
+		}
+	}
+	plaintext, err = ejsonjson.Canonicalize(decrypted)
+	return plaintext, err == nil, err
+}
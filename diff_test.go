@@ -0,0 +1,79 @@
+package ejson
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDiff(t *testing.T) {
+	Convey("Diff", t, func() {
+		encrypt := func(doc string) []byte {
+			var out bytes.Buffer
+			_, err := Encrypt(strings.NewReader(doc), &out)
+			So(err, ShouldBeNil)
+			return out.Bytes()
+		}
+		before := encrypt(`{"_public_key": "` + validPubKey + `", "_env": "prod", "a": "x", "b": "y", "gone": "z", "db": {"port": 5432}}`)
+		// Re-encrypting every value changes every ciphertext.
+		after := encrypt(`{"_public_key": "` + validPubKey + `", "_env": "production", "a": "x", "b": "changed", "db": {"port": 5433}, "new": "w"}`)
+
+		Convey("without a key, reports encrypted values as possibly changed", func() {
+			changes, err := Diff(bytes.NewReader(before), bytes.NewReader(after), DiffOptions{})
+			So(err, ShouldBeNil)
+			So(len(changes), ShouldEqual, 6)
+			So(changes[0], ShouldResemble, Change{Path: "_env", Kind: Changed, Old: []byte(`"prod"`), New: []byte(`"production"`)})
+			So(changes[1].Path, ShouldEqual, "a")
+			So(changes[1].Kind, ShouldEqual, PossiblyChanged)
+			So(changes[2].Path, ShouldEqual, "b")
+			So(changes[2].Kind, ShouldEqual, PossiblyChanged)
+			So(changes[3].Path, ShouldEqual, "gone")
+			So(changes[3].Kind, ShouldEqual, Removed)
+			So(changes[4], ShouldResemble, Change{Path: "db.port", Kind: Changed, Old: []byte(`5432`), New: []byte(`5433`)})
+			So(changes[5].Path, ShouldEqual, "new")
+			So(changes[5].Kind, ShouldEqual, Added)
+		})
+
+		Convey("with a key, confirms which plaintexts changed", func() {
+			changes, err := Diff(bytes.NewReader(before), bytes.NewReader(after), DiffOptions{UserSuppliedPrivateKey: validPrivKey, Values: true})
+			So(err, ShouldBeNil)
+			So(len(changes), ShouldEqual, 5)
+			So(changes[1], ShouldResemble, Change{Path: "b", Kind: Changed, Old: []byte(`"y"`), New: []byte(`"changed"`)})
+			So(changes[2], ShouldResemble, Change{Path: "gone", Kind: Removed, Old: []byte(`"z"`)})
+			So(changes[4], ShouldResemble, Change{Path: "new", Kind: Added, New: []byte(`"w"`)})
+		})
+
+		Convey("without Values, only decrypts values to compare them", func() {
+			var events []AuditEvent
+			audit := func(event AuditEvent) error {
+				events = append(events, event)
+				return nil
+			}
+			changes, err := Diff(bytes.NewReader(before), bytes.NewReader(after), DiffOptions{UserSuppliedPrivateKey: validPrivKey, Audit: audit})
+			So(err, ShouldBeNil)
+			So(len(changes), ShouldEqual, 5)
+			So(changes[1].Kind, ShouldEqual, Changed)
+			So(string(changes[1].New), ShouldStartWith, `"EJ[`)
+			So(string(changes[2].Old), ShouldStartWith, `"EJ[`)
+			So(string(changes[4].New), ShouldStartWith, `"EJ[`)
+			So(len(events), ShouldEqual, 2)
+			for _, event := range events {
+				So(event.Paths, ShouldResemble, []string{"a", "b"})
+			}
+		})
+
+		Convey("with a keydir that lacks the key, falls back to possibly changed", func() {
+			changes, err := Diff(bytes.NewReader(before), bytes.NewReader(after), DiffOptions{Keydir: t.TempDir()})
+			So(err, ShouldBeNil)
+			So(changes[1].Kind, ShouldEqual, PossiblyChanged)
+		})
+
+		Convey("reports nothing for identical documents", func() {
+			changes, err := Diff(bytes.NewReader(before), bytes.NewReader(before), DiffOptions{})
+			So(err, ShouldBeNil)
+			So(changes, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,133 @@
+package ejson
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/ejson/internal/atomicfile"
+	ejsonjson "github.com/Shopify/ejson/json"
+)
+
+// DirOptions controls how DirFiles lays out a decrypted document as files.
+type DirOptions struct {
+	// Path selects the subtree to export, as in SecretOptions.Path. If empty,
+	// the whole document is exported.
+	Path string
+	// Separator joins the keys (and array indexes) on the path to each value
+	// to make its file name, so that database.password is written to
+	// database_password with the default, "_".
+	Separator string
+	// Uppercase makes file names upper case, like environment variables.
+	Uppercase bool
+	// Perm is the mode the files are given by WriteDir; 0400 if zero.
+	Perm os.FileMode
+	// Force lets WriteDir replace a directory that it didn't write.
+	Force bool
+}
+
+// DirFiles lays out the values in a decrypted EJSON document as a set of
+// files, one for each string, number, boolean or null, keyed by file name.
+// Strings are written as they are, without quotes, and other values as JSON.
+// Members whose keys begin with an underscore, such as _public_key, are left
+// out, along with everything under them. Two values whose paths map to the
+// same file name are an error.
+func DirFiles(decrypted []byte, opts DirOptions) (map[string][]byte, error) {
+	if opts.Separator == "" {
+		opts.Separator = "_"
+	}
+	decrypted, err := ejsonjson.CollapseMultilineStringLiterals(decrypted)
+	if err != nil {
+		return nil, err
+	}
+	var root interface{}
+	dec := json.NewDecoder(bytes.NewReader(decrypted))
+	dec.UseNumber()
+	if err := dec.Decode(&root); err != nil {
+		return nil, err
+	}
+	selected, err := selectPath(root, opts.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	paths := make(map[string]string)
+	var collect func(value interface{}, keys []string) error
+	collect = func(value interface{}, keys []string) error {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			names := make([]string, 0, len(value))
+			for key := range value {
+				if !strings.HasPrefix(key, "_") {
+					names = append(names, key)
+				}
+			}
+			sort.Strings(names)
+			for _, key := range names {
+				if err := collect(value[key], append(keys, key)); err != nil {
+					return err
+				}
+			}
+			return nil
+		case []interface{}:
+			for i, elem := range value {
+				if err := collect(elem, append(keys, strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if len(keys) == 0 {
+			return fmt.Errorf("%s is not an object or array", describePath(opts.Path))
+		}
+		name := strings.Join(keys, opts.Separator)
+		if opts.Uppercase {
+			name = strings.ToUpper(name)
+		}
+		path := strings.Join(keys, ".")
+		if other, ok := paths[name]; ok {
+			return fmt.Errorf("%s and %s would both be written to %s", other, path, name)
+		}
+		paths[name] = path
+
+		if s, ok := value.(string); ok {
+			files[name] = []byte(s)
+			return nil
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		files[name] = data
+		return nil
+	}
+	if err := collect(selected, nil); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// WriteDir lays out a decrypted EJSON document as files, as DirFiles does,
+// and atomically replaces the contents of the directory dir with them, so
+// that files for values that are no longer in the document are removed. The
+// directory is created if it doesn't exist. An existing directory must
+// contain nothing but regular files, and, unless opts.Force is set, must be
+// empty or have been written by WriteDir, which leaves a marker file named
+// .ejson-dir in it; otherwise, nothing is written.
+func WriteDir(dir string, decrypted []byte, opts DirOptions) error {
+	files, err := DirFiles(decrypted, opts)
+	if err != nil {
+		return err
+	}
+	perm := opts.Perm
+	if perm == 0 {
+		perm = 0o400
+	}
+	return atomicfile.ReplaceDir(dir, files, atomicfile.Options{Perm: perm, Overwrite: opts.Force})
+}
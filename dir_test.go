@@ -0,0 +1,69 @@
+package ejson
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/ejson/internal/atomicfile"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDirFiles(t *testing.T) {
+	Convey("DirFiles", t, func() {
+		decrypted := []byte(`{"_public_key": "` + validPubKey + `", "db": {"password": "p\nw", "port": 5432, "hosts": ["a", "b"], "_note": "x"}, "tls": null}`)
+
+		Convey("should name files by path, leaving out underscored keys", func() {
+			files, err := DirFiles(decrypted, DirOptions{})
+			So(err, ShouldBeNil)
+			So(files, ShouldResemble, map[string][]byte{
+				"db_password": []byte("p\nw"),
+				"db_port":     []byte("5432"),
+				"db_hosts_0":  []byte("a"),
+				"db_hosts_1":  []byte("b"),
+				"tls":         []byte("null"),
+			})
+		})
+
+		Convey("should export a subtree with the chosen mapping", func() {
+			files, err := DirFiles(decrypted, DirOptions{Path: "db", Separator: "-", Uppercase: true})
+			So(err, ShouldBeNil)
+			So(len(files), ShouldEqual, 4)
+			So(string(files["HOSTS-1"]), ShouldEqual, "b")
+		})
+
+		Convey("should refuse paths that map to the same file", func() {
+			_, err := DirFiles([]byte(`{"a_b": "1", "a": {"b": "2"}}`), DirOptions{})
+			So(err, ShouldNotBeNil)
+			_, err = DirFiles(decrypted, DirOptions{Path: "db.port"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("WriteDir", t, func() {
+		dir := filepath.Join(t.TempDir(), "secrets")
+
+		Convey("should replace a directory it wrote", func() {
+			So(WriteDir(dir, []byte(`{"a": "1", "b": "2"}`), DirOptions{}), ShouldBeNil)
+			So(WriteDir(dir, []byte(`{"a": "3"}`), DirOptions{}), ShouldBeNil)
+			entries, _ := os.ReadDir(dir)
+			So(len(entries), ShouldEqual, 2) // a and the marker
+			info, _ := os.Stat(filepath.Join(dir, "a"))
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0o400))
+		})
+
+		Convey("should leave other directories alone unless forced", func() {
+			So(os.Mkdir(dir, 0o755), ShouldBeNil)
+			So(os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep"), 0o644), ShouldBeNil)
+			err := WriteDir(dir, []byte(`{"a": "1"}`), DirOptions{})
+			So(errors.Is(err, atomicfile.ErrNotOwnDir), ShouldBeTrue)
+			data, _ := os.ReadFile(filepath.Join(dir, "notes.txt"))
+			So(string(data), ShouldEqual, "keep")
+
+			So(WriteDir(dir, []byte(`{"a": "1"}`), DirOptions{Force: true}), ShouldBeNil)
+			_, err = os.Stat(filepath.Join(dir, "notes.txt"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
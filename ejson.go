@@ -5,13 +5,18 @@ package ejson
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/Shopify/ejson/crypto"
+	"github.com/Shopify/ejson/internal/atomicfile"
 	"github.com/Shopify/ejson/json"
 )
 
@@ -26,12 +31,95 @@ func GenerateKeypair() (pub string, priv string, err error) {
 	return kp.PublicString(), kp.PrivateString(), nil
 }
 
+// KeypairFromSSH derives an ejson keypair from an OpenSSH ed25519 private key,
+// returning the keys in the same form as GenerateKeypair. The passphrase is
+// only needed if the SSH key is encrypted. The public key is the same as the
+// one PublicKeyFromSSH derives from the matching SSH public key.
+func KeypairFromSSH(privateKey, passphrase []byte) (pub string, priv string, err error) {
+	var kp crypto.Keypair
+	if err := kp.FromSSHPrivateKey(privateKey, passphrase); err != nil {
+		return "", "", err
+	}
+	return kp.PublicString(), kp.PrivateString(), nil
+}
+
+// KeypairFromAge returns the ejson keypair for an age X25519 identity, given
+// the contents of an identity file as written by age-keygen, in the same form
+// as GenerateKeypair.
+func KeypairFromAge(identity []byte) (pub string, priv string, err error) {
+	privkey, err := parsePrivateKey(string(identity))
+	if err != nil {
+		return "", "", err
+	}
+	var kp crypto.Keypair
+	kp.FromPrivate(privkey)
+	return kp.PublicString(), kp.PrivateString(), nil
+}
+
+// AgeKeys converts a hex-encoded keypair, as returned by GenerateKeypair, to
+// an age recipient and identity. If priv is empty, so is the identity.
+func AgeKeys(pub, priv string) (recipient string, identity string, err error) {
+	var kp crypto.Keypair
+	if kp.Public, err = json.ParsePublicKey(pub); err != nil {
+		return "", "", err
+	}
+	if priv != "" {
+		if kp.Private, err = parsePrivateKey(priv); err != nil {
+			return "", "", err
+		}
+		identity = kp.AgeIdentity()
+	}
+	return kp.AgeRecipient(), identity, nil
+}
+
+// EncryptAge encrypts data, such as a decrypted document, in the age format,
+// so that it can be decrypted by age with the identity of any of the given
+// recipients. Recipients may be age recipients or hex-encoded public keys.
+func EncryptAge(data []byte, recipients []string) ([]byte, error) {
+	keys := make([][32]byte, 0, len(recipients))
+	for _, recipient := range recipients {
+		key, err := json.ParsePublicKey(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, recipient)
+		}
+		keys = append(keys, key)
+	}
+	return crypto.EncryptAge(data, keys...)
+}
+
+// PublicKeyFromSSH derives an ejson public key from an ed25519 SSH public key,
+// given as a line of an authorized_keys file. It also returns the key's
+// comment, if it has one.
+func PublicKeyFromSSH(authorizedKey []byte) (pub string, comment string, err error) {
+	key, comment, err := crypto.SSHPublicKey(authorizedKey)
+	if err != nil {
+		return "", "", err
+	}
+	return fmt.Sprintf("%x", key), comment, nil
+}
+
+// EncryptOptions changes how values are encrypted. Options set by a document's
+// _ejson policy apply in addition to these.
+type EncryptOptions struct {
+	// Pad pads values before encrypting them, so that the length of their
+	// ciphertext doesn't reveal their exact length.
+	Pad bool
+	// Compress compresses values before encrypting them, where that makes
+	// them smaller.
+	Compress bool
+}
+
 // Encrypt reads all contents from 'in', extracts the pubkey
 // and performs the requested encryption operation, writing
 // the resulting data to 'out'.
 // Returns the number of bytes written and any error that might have
 // occurred.
 func Encrypt(in io.Reader, out io.Writer) (int, error) {
+	return EncryptWithOptions(in, out, EncryptOptions{})
+}
+
+// EncryptWithOptions is like Encrypt, but with the given options.
+func EncryptWithOptions(in io.Reader, out io.Writer, opts EncryptOptions) (int, error) {
 	data, err := io.ReadAll(in)
 	if err != nil {
 		return -1, err
@@ -52,9 +140,31 @@ func Encrypt(in io.Reader, out io.Writer) (int, error) {
 		return -1, err
 	}
 
+	selectLiteral, err := json.ExtractLiteralSelector(data)
+	if err != nil {
+		return -1, err
+	}
+
+	policy, err := json.ExtractPolicy(data)
+	if err != nil {
+		return -1, err
+	}
+
 	encrypter := myKP.Encrypter(pubkey)
+	encrypter.Pad = opts.Pad || (policy != nil && policy.Pad)
+	encrypter.Compress = opts.Compress || (policy != nil && policy.Compress)
 	walker := json.Walker{
-		Action: encrypter.Encrypt,
+		ValueAction: func(v json.Value) (json.Value, error) {
+			var err error
+			switch {
+			case !v.Literal:
+				v.Data, err = encrypter.Encrypt(v.Data)
+			case selectLiteral(v.Path):
+				v.Data, err = encrypter.EncryptLiteral(v.Data)
+				v.Literal = false
+			}
+			return v, err
+		},
 	}
 
 	newdata, err := walker.Walk(data)
@@ -70,74 +180,153 @@ func Encrypt(in io.Reader, out io.Writer) (int, error) {
 // encryptable-but-unencrypted fields in the file will be encrypted using the
 // public key embdded in the file, and the resulting text will be written over
 // the file present on disk.
+//
+// The file is replaced atomically, keeping its mode and ownership, and an
+// advisory lock prevents concurrent calls from overwriting each other's
+// changes. If filePath is a symlink, its target is updated.
 func EncryptFileInPlace(filePath string) (int, error) {
-	var fileMode os.FileMode
-	if stat, err := os.Stat(filePath); err == nil {
-		fileMode = stat.Mode()
-	} else {
-		return -1, err
-	}
+	return EncryptFileInPlaceWithOptions(filePath, EncryptOptions{})
+}
 
-	file, err := os.Open(filePath)
+// EncryptFileInPlaceWithOptions is like EncryptFileInPlace, but with the given
+// options.
+func EncryptFileInPlaceWithOptions(filePath string, opts EncryptOptions) (int, error) {
+	var written int
+	_, err := atomicfile.Update(filePath, func(data []byte) ([]byte, error) {
+		var outBuffer bytes.Buffer
+		n, err := EncryptWithOptions(bytes.NewReader(data), &outBuffer, opts)
+		written = n
+		return outBuffer.Bytes(), err
+	})
 	if err != nil {
 		return -1, err
 	}
+	return written, nil
+}
 
-	var outBuffer bytes.Buffer
-
-	written, err := Encrypt(file, &outBuffer)
-	if err != nil {
-		return -1, err
-	}
+// DecryptOptions changes how documents are decrypted.
+type DecryptOptions struct {
+	// TrustedSigners, if not empty, makes decryption refuse documents that
+	// aren't validly signed by one of these keys (see Sign).
+	TrustedSigners []ed25519.PublicKey
+	// Keys, if not nil, is where the private key is looked up, instead of the
+	// keydir or user-supplied private key.
+	Keys KeyProvider
+	// Paths, if not empty, limits decryption to the values at these paths
+	// (as in json.Value.Path) and the values nested under them. Other values
+	// are left encrypted.
+	Paths []string
+	// Audit, if not nil, is called after each attempt to decrypt a document,
+	// whether it succeeds or not, with a record of which values were
+	// decrypted (see JSONAuditLog).
+	Audit AuditFunc
+}
 
-	if err = file.Close(); err != nil {
-		return -1, err
+// selects reports whether the value at path should be decrypted.
+func (o DecryptOptions) selects(path string) bool {
+	if len(o.Paths) == 0 {
+		return true
 	}
-
-	if err := os.WriteFile(filePath, outBuffer.Bytes(), fileMode); err != nil {
-		return -1, err
+	for _, p := range o.Paths {
+		if path == p || strings.HasPrefix(path, p+".") || strings.HasPrefix(path, p+"[") {
+			return true
+		}
 	}
-
-	return written, nil
+	return false
 }
 
 // Decrypt reads an ejson stream from 'in' and writes the decrypted data to 'out'.
 // The private key is expected to be under 'keydir'.
 // Returns error upon failure, or nil on success.
 func Decrypt(in io.Reader, out io.Writer, keydir string, userSuppliedPrivateKey string) error {
-	data, err := io.ReadAll(in)
-	if err != nil {
+	return DecryptWithOptions(in, out, keydir, userSuppliedPrivateKey, DecryptOptions{})
+}
+
+// DecryptWithOptions is like Decrypt, but with the given options.
+func DecryptWithOptions(in io.Reader, out io.Writer, keydir string, userSuppliedPrivateKey string, opts DecryptOptions) error {
+	return decrypt(in, out, keydir, userSuppliedPrivateKey, opts, "")
+}
+
+// decrypt implements DecryptWithOptions, giving file as the path of the
+// document in audit events.
+func decrypt(in io.Reader, out io.Writer, keydir string, userSuppliedPrivateKey string, opts DecryptOptions, file string) error {
+	if opts.Audit == nil {
+		newdata, err := decryptDocument(in, keydir, userSuppliedPrivateKey, opts, nil)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(newdata)
 		return err
 	}
 
-	pubkey, err := json.ExtractPublicKey(data)
-	if err != nil {
+	event := NewAuditEvent(file)
+	newdata, err := decryptDocument(in, keydir, userSuppliedPrivateKey, opts, &event)
+	if err := recordAudit(opts.Audit, event, err); err != nil {
 		return err
 	}
+	_, err = out.Write(newdata)
+	return err
+}
 
-	privkey, err := findPrivateKey(pubkey, keydir, userSuppliedPrivateKey)
+// decryptDocument decrypts the document read from in. If event is not nil,
+// the document's public key and the paths of the values decrypted are
+// recorded in it.
+func decryptDocument(in io.Reader, keydir string, userSuppliedPrivateKey string, opts DecryptOptions, event *AuditEvent) ([]byte, error) {
+	data, err := io.ReadAll(in)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	myKP := crypto.Keypair{
-		Public:  pubkey,
-		Private: privkey,
+	if len(opts.TrustedSigners) > 0 {
+		collapsed, err := json.CollapseMultilineStringLiterals(data)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := verifySignature(collapsed, opts.TrustedSigners); err != nil {
+			return nil, err
+		}
 	}
 
-	decrypter := myKP.Decrypter()
-	walker := json.Walker{
-		Action: decrypter.Decrypt,
+	pubkey, err := json.ExtractPublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	if event != nil {
+		event.PublicKey = fmt.Sprintf("%x", pubkey)
 	}
 
-	newdata, err := walker.Walk(data)
+	keys := opts.Keys
+	if keys == nil {
+		keys = keyProvider(keydir, userSuppliedPrivateKey)
+	}
+	privkey, err := lookupPrivateKey(pubkey, keys)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = out.Write(newdata)
+	var myKP crypto.Keypair
+	myKP.FromPrivate(privkey)
 
-	return err
+	var mu sync.Mutex
+	decrypter := myKP.Decrypter()
+	walker := json.Walker{
+		ValueAction: func(v json.Value) (json.Value, error) {
+			if v.Literal || !opts.selects(v.Path) {
+				return v, nil
+			}
+			encrypted := crypto.IsBoxedMessage(v.Data)
+			var err error
+			v.Data, v.Literal, err = decrypter.DecryptValue(v.Data)
+			if err == nil && encrypted && event != nil {
+				mu.Lock()
+				event.Paths = append(event.Paths, v.Path)
+				mu.Unlock()
+			}
+			return v, err
+		},
+	}
+
+	return walker.Walk(data)
 }
 
 // DecryptFile takes a path to an encrypted EJSON file and returns the data
@@ -147,6 +336,11 @@ func Decrypt(in io.Reader, out io.Writer, keydir string, userSuppliedPrivateKey
 // EJSON document, and whose contents are the corresponding private key. See
 // README.md for more details on this.
 func DecryptFile(filePath, keydir string, userSuppliedPrivateKey string) ([]byte, error) {
+	return DecryptFileWithOptions(filePath, keydir, userSuppliedPrivateKey, DecryptOptions{})
+}
+
+// DecryptFileWithOptions is like DecryptFile, but with the given options.
+func DecryptFileWithOptions(filePath, keydir string, userSuppliedPrivateKey string, opts DecryptOptions) ([]byte, error) {
 	if _, err := os.Stat(filePath); err != nil {
 		return nil, err
 	}
@@ -159,17 +353,115 @@ func DecryptFile(filePath, keydir string, userSuppliedPrivateKey string) ([]byte
 
 	var outBuffer bytes.Buffer
 
-	err = Decrypt(file, &outBuffer, keydir, userSuppliedPrivateKey)
+	err = decrypt(file, &outBuffer, keydir, userSuppliedPrivateKey, opts, filePath)
 
 	return outBuffer.Bytes(), err
 }
 
+// ValueOrigin records which of the files given to DecryptMerge a value in the
+// merged document came from.
+type ValueOrigin struct {
+	Path string
+	File string
+}
+
+// DecryptMerge decrypts each of the given EJSON files, which may have
+// different public keys, and merges the results, each on top of those before
+// it (see json.Merge): objects are merged recursively, any other value,
+// including an array, replaces what it is merged onto, and null removes it.
+// The per-file _public_key, _ejson, _encrypt_literals and _signature fields
+// are left out of the result. It also returns the file each value in the
+// result came from.
+func DecryptMerge(filePaths []string, keydir string, userSuppliedPrivateKey string, opts DecryptOptions) ([]byte, []ValueOrigin, error) {
+	docs := make([][]byte, len(filePaths))
+	for i, filePath := range filePaths {
+		decrypted, err := DecryptFileWithOptions(filePath, keydir, userSuppliedPrivateKey, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filePath, err)
+		}
+		if docs[i], err = json.CollapseMultilineStringLiterals(decrypted); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filePath, err)
+		}
+	}
+
+	merged, origins, err := json.Merge(docs, json.PublicKeyField, json.PolicyField, json.EncryptLiteralsField, json.SignatureField)
+	if err != nil {
+		return nil, nil, err
+	}
+	valueOrigins := make([]ValueOrigin, len(origins))
+	for i, origin := range origins {
+		valueOrigins[i] = ValueOrigin{Path: origin.Path, File: filePaths[origin.Layer]}
+	}
+	return merged, valueOrigins, nil
+}
+
+// Check reads an EJSON document from 'in' and verifies that it is valid: that
+// it is well-formed JSON, has a valid public key, and a valid encryption
+// policy and literal selection if it has either, and that every value that
+// should be encrypted is. Every unencrypted value is reported, as a
+// *json.ValueError wrapping ErrNotEncrypted; the errors are combined with
+// errors.Join.
+func Check(in io.Reader) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	data, err = json.CollapseMultilineStringLiterals(data)
+	if err != nil {
+		return err
+	}
+
+	if _, err = json.ExtractPublicKey(data); err != nil {
+		return err
+	}
+
+	selectLiteral, err := json.ExtractLiteralSelector(data)
+	if err != nil {
+		return err
+	}
+
+	var (
+		mu       sync.Mutex
+		problems []error
+	)
+	walker := json.Walker{
+		ValueAction: func(v json.Value) (json.Value, error) {
+			if (v.Literal && selectLiteral(v.Path)) || (!v.Literal && !crypto.IsBoxedMessage(v.Data)) {
+				mu.Lock()
+				defer mu.Unlock()
+				problems = append(problems, &json.ValueError{Path: v.Path, Err: ErrNotEncrypted})
+			}
+			return v, nil
+		},
+	}
+	if _, err = walker.Walk(data); err != nil {
+		return err
+	}
+
+	sort.Slice(problems, func(i, j int) bool {
+		return problems[i].(*json.ValueError).Path < problems[j].(*json.ValueError).Path
+	})
+	return errors.Join(problems...)
+}
+
+// CheckFile is like Check, but reads the document from the file at filePath.
+func CheckFile(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return Check(file)
+}
+
 func readPrivateKeyFromDisk(pubkey [32]byte, keydir string) (privkey string, err error) {
 	keyFile := fmt.Sprintf("%s/%x", keydir, pubkey)
 	var fileContents []byte
 	fileContents, err = os.ReadFile(keyFile)
 	if err != nil {
-		err = fmt.Errorf("couldn't read key file (%s)", err.Error())
+		err = &KeyFileError{Path: keyFile, Err: err}
 		return
 	}
 	privkey = string(fileContents)
@@ -177,25 +469,48 @@ func readPrivateKeyFromDisk(pubkey [32]byte, keydir string) (privkey string, err
 }
 
 func findPrivateKey(pubkey [32]byte, keydir string, userSuppliedPrivateKey string) (privkey [32]byte, err error) {
-	var privkeyString string
-	if userSuppliedPrivateKey != "" {
-		privkeyString = userSuppliedPrivateKey
-	} else {
-		privkeyString, err = readPrivateKeyFromDisk(pubkey, keydir)
-		if err != nil {
-			return privkey, err
+	return lookupPrivateKey(pubkey, keyProvider(keydir, userSuppliedPrivateKey))
+}
+
+// parsePrivateKey decodes a private key, as stored in the keydir: either
+// hex-encoded, or as an age identity, optionally with the comments age-keygen
+// writes along with it.
+func parsePrivateKey(privkeyString string) (privkey [32]byte, err error) {
+	privkeyString = strings.TrimSpace(privkeyString)
+	if identity, ok := ageIdentity(privkeyString); ok {
+		if privkey, err = crypto.ParseAgeIdentity(identity); err != nil {
+			err = fmt.Errorf("%w: %w", ErrInvalidPrivateKey, err)
 		}
+		return
 	}
 
-	privkeyBytes, err := hex.DecodeString(strings.TrimSpace(privkeyString))
+	privkeyBytes, err := hex.DecodeString(privkeyString)
 	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrInvalidPrivateKey, err)
 		return
 	}
 
 	if len(privkeyBytes) != 32 {
-		err = fmt.Errorf("invalid private key")
+		err = ErrInvalidPrivateKey
 		return
 	}
 	copy(privkey[:], privkeyBytes)
 	return
 }
+
+// ageIdentity returns the age identity in the contents of an age identity
+// file, if that's what they are.
+func ageIdentity(contents string) (string, bool) {
+	var identity string
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if identity != "" || !crypto.IsAgeIdentity(line) {
+			return "", false
+		}
+		identity = line
+	}
+	return identity, identity != ""
+}
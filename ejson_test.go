@@ -1,11 +1,16 @@
 package ejson
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path"
 	"regexp"
+	"strings"
 	"testing"
 
+	"github.com/Shopify/ejson/json"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -29,6 +34,45 @@ func TestGenerateKeypair(t *testing.T) {
 	})
 }
 
+func TestAgeKeys(t *testing.T) {
+	Convey("age keys", t, func() {
+		pub, priv, err := GenerateKeypair()
+		So(err, ShouldBeNil)
+		recipient, identity, err := AgeKeys(pub, priv)
+		So(err, ShouldBeNil)
+		So(recipient, ShouldStartWith, "age1")
+		So(identity, ShouldStartWith, "AGE-SECRET-KEY-1")
+
+		Convey("should convert back from an age identity file", func() {
+			file := "# created: 2026-10-19T00:00:00Z\n# public key: " + recipient + "\n" + identity + "\n"
+			gotPub, gotPriv, err := KeypairFromAge([]byte(file))
+			So(err, ShouldBeNil)
+			So(gotPub, ShouldEqual, pub)
+			So(gotPriv, ShouldEqual, priv)
+		})
+
+		Convey("should be usable in documents and as private keys", func() {
+			var encrypted bytes.Buffer
+			_, err := Encrypt(strings.NewReader(`{"_public_key": "`+recipient+`", "a": "b"}`), &encrypted)
+			So(err, ShouldBeNil)
+			So(encrypted.String(), ShouldContainSubstring, `"_public_key": "`+recipient+`"`)
+
+			var decrypted bytes.Buffer
+			So(Decrypt(&encrypted, &decrypted, "", identity), ShouldBeNil)
+			So(decrypted.String(), ShouldEqual, `{"_public_key": "`+recipient+`", "a": "b"}`)
+		})
+
+		Convey("should reject an invalid identity", func() {
+			last := "Q"
+			if strings.HasSuffix(identity, last) {
+				last = "P"
+			}
+			_, _, err := KeypairFromAge([]byte(identity[:len(identity)-1] + last))
+			So(errors.Is(err, ErrInvalidPrivateKey), ShouldBeTrue)
+		})
+	})
+}
+
 func setData(path string, data []byte) error {
 	tmpFile, err := os.OpenFile(path, os.O_TRUNC|os.O_WRONLY, 0o600)
 	if err != nil {
@@ -95,6 +139,67 @@ func TestEncryptFileInPlace(t *testing.T) {
 			})
 		})
 
+		Convey("called with a document that opts in to encrypting literals", func() {
+			setData(tempFileName, []byte(`{"_public_key": "`+validPubKey+`", "_encrypt_literals": ["a"], "a": 500, "b": true}`))
+
+			_, err := EncryptFileInPlace(tempFileName)
+			So(err, ShouldBeNil)
+			output, err := os.ReadFile(tempFileName)
+			So(err, ShouldBeNil)
+			Convey("should encrypt the selected literals only", func() {
+				match := regexp.MustCompile(`"a": "EJ\[2:n:.*", "b": true}`)
+				So(match.Find(output), ShouldNotBeNil)
+			})
+			Convey("should restore their type on decryption", func() {
+				keydir, err := os.MkdirTemp("", "ejson_keys")
+				So(err, ShouldBeNil)
+				defer os.RemoveAll(keydir)
+				So(os.WriteFile(path.Join(keydir, validPubKey), []byte(validPrivKey), 0o600), ShouldBeNil)
+				out, err := DecryptFile(tempFileName, keydir, "")
+				So(err, ShouldBeNil)
+				So(string(out), ShouldEqual, `{"_public_key": "`+validPubKey+`", "_encrypt_literals": ["a"], "a": 500, "b": true}`)
+			})
+		})
+
+		Convey("called with padding requested", func() {
+			Convey("by the document's policy", func() {
+				setData(tempFileName, []byte(`{"_public_key": "`+validPubKey+`", "_ejson": {"pad": true}, "a": "b"}`))
+				_, err := EncryptFileInPlace(tempFileName)
+				So(err, ShouldBeNil)
+				output, err := os.ReadFile(tempFileName)
+				So(err, ShouldBeNil)
+				So(string(output), ShouldContainSubstring, `"a": "EJ[2:p:`)
+			})
+			Convey("by the caller", func() {
+				setData(tempFileName, []byte(`{"_public_key": "`+validPubKey+`", "a": "b"}`))
+				_, err := EncryptFileInPlaceWithOptions(tempFileName, EncryptOptions{Pad: true})
+				So(err, ShouldBeNil)
+				output, err := os.ReadFile(tempFileName)
+				So(err, ShouldBeNil)
+				So(string(output), ShouldContainSubstring, `"a": "EJ[2:p:`)
+
+				out, err := DecryptFile(tempFileName, "", validPrivKey)
+				So(err, ShouldBeNil)
+				So(string(out), ShouldEqual, `{"_public_key": "`+validPubKey+`", "a": "b"}`)
+			})
+		})
+
+		Convey("called with a document that opts in to compression", func() {
+			bundle := strings.Repeat(`-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n`, 20)
+			doc := `{"_public_key": "` + validPubKey + `", "_ejson": {"compress": true}, "tls": "` + bundle + `", "pin": "1234"}`
+			setData(tempFileName, []byte(doc))
+			_, err := EncryptFileInPlace(tempFileName)
+			So(err, ShouldBeNil)
+			output, err := os.ReadFile(tempFileName)
+			So(err, ShouldBeNil)
+			So(string(output), ShouldContainSubstring, `"tls": "EJ[2:c:`)
+			So(string(output), ShouldContainSubstring, `"pin": "EJ[1:`)
+
+			out, err := DecryptFile(tempFileName, "", validPrivKey)
+			So(err, ShouldBeNil)
+			So(string(out), ShouldEqual, doc)
+		})
+
 		Convey("called with a valid keypair and multiline string", func() {
 			setData(tempFileName, []byte(`{"_public_key": "`+validPubKey+"\", \"a\": \"b\nc\"\n}"))
 
@@ -161,6 +266,7 @@ func TestDecryptFile(t *testing.T) {
 			Convey("should fail and describe that the key could not be found", func() {
 				So(err, ShouldNotBeNil)
 				So(err.Error(), ShouldContainSubstring, "couldn't read key file")
+				So(errors.Is(err, ErrKeyNotFound), ShouldBeTrue)
 			})
 		})
 
@@ -179,15 +285,29 @@ func TestDecryptFile(t *testing.T) {
 			Convey("should fail with invalid private key message", func() {
 				So(err, ShouldNotBeNil)
 				So(err.Error(), ShouldEqual, "invalid private key")
+				So(errors.Is(err, ErrInvalidPrivateKey), ShouldBeTrue)
 			})
 		})
 
 		Convey("called with a valid public key and an incorrect private key supplied via CLI", func() {
 			setData(tempFileName, []byte(`{"_public_key": "`+validPubKey+`", "a": "EJ[1:KR1IxNZnTZQMP3OR1NdOpDQ1IcLD83FSuE7iVNzINDk=:XnYW1HOxMthBFMnxWULHlnY4scj5mNmX:ls1+kvwwu2ETz5C6apgWE7Q=]"}`))
 			_, err := DecryptFile(tempFileName, tempDir, incorrectPrivKey)
-			Convey("should fail with could not decrypt message", func() {
+			Convey("should fail because the key doesn't match", func() {
 				So(err, ShouldNotBeNil)
-				So(err.Error(), ShouldEqual, "couldn't decrypt message")
+				So(err.Error(), ShouldEqual, "private key does not match public key "+validPubKey)
+				So(errors.Is(err, ErrKeyMismatch), ShouldBeTrue)
+			})
+		})
+
+		Convey("called with a mislabelled key file in keydir", func() {
+			otherDir, err := os.MkdirTemp("", "ejson_keys")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(otherDir)
+			So(os.WriteFile(path.Join(otherDir, validPubKey), []byte(incorrectPrivKey), 0o600), ShouldBeNil)
+			setData(tempFileName, []byte(`{"_public_key": "`+validPubKey+`", "a": "EJ[1:KR1IxNZnTZQMP3OR1NdOpDQ1IcLD83FSuE7iVNzINDk=:XnYW1HOxMthBFMnxWULHlnY4scj5mNmX:ls1+kvwwu2ETz5C6apgWE7Q=]"}`))
+			_, err = DecryptFile(tempFileName, otherDir, "")
+			Convey("should fail because the key doesn't match", func() {
+				So(errors.Is(err, ErrKeyMismatch), ShouldBeTrue)
 			})
 		})
 
@@ -199,5 +319,79 @@ func TestDecryptFile(t *testing.T) {
 				So(string(out), ShouldEqual, `{"_public_key": "`+validPubKey+`", "a": "b"}`)
 			})
 		})
+
+		Convey("called with a KeyProvider and a selection of paths", func() {
+			const ciphertext = "EJ[1:KR1IxNZnTZQMP3OR1NdOpDQ1IcLD83FSuE7iVNzINDk=:XnYW1HOxMthBFMnxWULHlnY4scj5mNmX:ls1+kvwwu2ETz5C6apgWE7Q=]"
+			setData(tempFileName, []byte(`{"_public_key": "`+validPubKey+`", "a": "`+ciphertext+`", "b": ["`+ciphertext+`"]}`))
+			var asked string
+			keys := KeyProviderFunc(func(pub [32]byte) (string, error) {
+				asked = fmt.Sprintf("%x", pub)
+				return validPrivKey, nil
+			})
+			out, err := DecryptFileWithOptions(tempFileName, "/doesnt/matter", "", DecryptOptions{Keys: keys, Paths: []string{"b"}})
+			Convey("should get the key from the provider and only decrypt those paths", func() {
+				So(err, ShouldBeNil)
+				So(asked, ShouldEqual, validPubKey)
+				So(string(out), ShouldEqual, `{"_public_key": "`+validPubKey+`", "a": "`+ciphertext+`", "b": ["b"]}`)
+			})
+		})
+	})
+}
+
+func TestDecryptMerge(t *testing.T) {
+	Convey("DecryptMerge", t, func() {
+		keydir := t.TempDir()
+		So(os.WriteFile(path.Join(keydir, validPubKey), []byte(validPrivKey), 0o600), ShouldBeNil)
+		otherPub, otherPriv, err := GenerateKeypair()
+		So(err, ShouldBeNil)
+		So(os.WriteFile(path.Join(keydir, otherPub), []byte(otherPriv), 0o600), ShouldBeNil)
+
+		common := path.Join(keydir, "common.ejson")
+		production := path.Join(keydir, "production.ejson")
+		So(os.WriteFile(common, []byte(`{"_public_key": "`+validPubKey+`", "db": {"host": "localhost", "password": "dev"}, "debug": true}`), 0o600), ShouldBeNil)
+		So(os.WriteFile(production, []byte(`{"_public_key": "`+otherPub+`", "db": {"password": "prod"}, "debug": null}`), 0o600), ShouldBeNil)
+		for _, file := range []string{common, production} {
+			_, err := EncryptFileInPlace(file)
+			So(err, ShouldBeNil)
+		}
+
+		merged, origins, err := DecryptMerge([]string{common, production}, keydir, "", DecryptOptions{})
+		So(err, ShouldBeNil)
+		So(string(merged), ShouldEqual, "{\n  \"db\": {\n    \"host\": \"localhost\",\n    \"password\": \"prod\"\n  }\n}\n")
+		So(origins, ShouldResemble, []ValueOrigin{
+			{Path: "db.host", File: common},
+			{Path: "db.password", File: production},
+		})
+
+		Convey("should name the file that failed", func() {
+			_, _, err := DecryptMerge([]string{common, production}, keydir, validPrivKey, DecryptOptions{})
+			So(errors.Is(err, ErrKeyMismatch), ShouldBeTrue)
+			So(err.Error(), ShouldStartWith, production+": ")
+		})
+	})
+}
+
+func TestCheck(t *testing.T) {
+	Convey("Check", t, func() {
+		Convey("accepts a fully encrypted document", func() {
+			err := Check(strings.NewReader(`{"_public_key": "` + validPubKey + `", "_a": "b", "a": "EJ[1:KR1IxNZnTZQMP3OR1NdOpDQ1IcLD83FSuE7iVNzINDk=:XnYW1HOxMthBFMnxWULHlnY4scj5mNmX:ls1+kvwwu2ETz5C6apgWE7Q=]"}`))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("reports every unencrypted value", func() {
+			err := Check(strings.NewReader(`{"_public_key": "` + validPubKey + `", "_encrypt_literals": ["n"], "a": "b", "c": {"d": "e"}, "n": 1}`))
+			So(errors.Is(err, ErrNotEncrypted), ShouldBeTrue)
+			So(err.Error(), ShouldEqual, "a: value is not encrypted\nc.d: value is not encrypted\nn: value is not encrypted")
+		})
+
+		Convey("honours the encryption policy", func() {
+			err := Check(strings.NewReader(`{"_public_key": "` + validPubKey + `", "_ejson": {"exclude": ["a"]}, "a": "b"}`))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("rejects an invalid encryption policy", func() {
+			err := Check(strings.NewReader(`{"_public_key": "` + validPubKey + `", "_ejson": {"include": "a"}}`))
+			So(errors.Is(err, json.ErrPolicyInvalid), ShouldBeTrue)
+		})
 	})
 }
@@ -0,0 +1,39 @@
+package ejson
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// ErrKeyNotFound means that no private key matching the document's public key
+// was present in the keydir.
+var ErrKeyNotFound = errors.New("private key not found")
+
+// ErrInvalidPrivateKey means that a private key, either read from the keydir
+// or supplied by the user, was not a hex-encoded 32-byte key.
+var ErrInvalidPrivateKey = errors.New("invalid private key")
+
+// ErrNotEncrypted means that a value which should have been encrypted was
+// found in plaintext.
+var ErrNotEncrypted = errors.New("value is not encrypted")
+
+// KeyFileError records a failure to read a private key from the keydir. It
+// matches ErrKeyNotFound with errors.Is when the key file doesn't exist.
+type KeyFileError struct {
+	Path string
+	Err  error
+}
+
+func (e *KeyFileError) Error() string {
+	return fmt.Sprintf("couldn't read key file (%s)", e.Err)
+}
+
+func (e *KeyFileError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrKeyNotFound and the key file is missing.
+func (e *KeyFileError) Is(target error) bool {
+	return target == ErrKeyNotFound && errors.Is(e.Err, fs.ErrNotExist)
+}
@@ -0,0 +1,286 @@
+// Package atomicfile writes files so that readers, and the file system after
+// a crash, only ever see either the old contents or the complete new ones.
+//
+// New contents are written to a temporary file in the same directory, which
+// is flushed to disk and then renamed over the original. The directory is
+// flushed too, so that the rename itself is durable.
+package atomicfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrSymlink is returned by WriteFile when asked to write to a symlink
+// without Options.FollowSymlinks.
+var ErrSymlink = errors.New("refusing to write through symlink")
+
+// Options controls how WriteFile creates files.
+type Options struct {
+	// Perm is the mode the file is given. The umask is not applied.
+	Perm os.FileMode
+	// Overwrite allows an existing file to be replaced.
+	Overwrite bool
+	// FollowSymlinks allows writing to the target of a symlink.
+	FollowSymlinks bool
+}
+
+// WriteFile atomically writes data to the file at path, which must not exist
+// unless opts.Overwrite is set. Readers never see a partially written file,
+// and nothing is left behind if writing fails.
+func WriteFile(path string, data []byte, opts Options) error {
+	info, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	case info.Mode()&os.ModeSymlink != 0:
+		if !opts.FollowSymlinks {
+			return &os.PathError{Op: "write", Path: path, Err: ErrSymlink}
+		}
+		if path, err = filepath.EvalSymlinks(path); err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err == nil && !opts.Overwrite {
+			return &os.PathError{Op: "write", Path: path, Err: os.ErrExist}
+		}
+	case !opts.Overwrite:
+		return &os.PathError{Op: "write", Path: path, Err: os.ErrExist}
+	}
+
+	tmp, err := writeTemp(path, data, opts.Perm, nil)
+	if err != nil {
+		return err
+	}
+	if opts.Overwrite {
+		err = os.Rename(tmp, path)
+	} else {
+		// Unlike a rename, a link fails if something has appeared at path in
+		// the meantime.
+		err = os.Link(tmp, path)
+		os.Remove(tmp)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// WriteFiles creates several new files at once, each with the mode perm, so
+// that either all of them are written or none are. None of the files may
+// exist already.
+//
+// Every file is written to a temporary file and flushed to disk before any of
+// them is linked into place, and if linking one fails, those already linked
+// are removed again.
+func WriteFiles(files map[string][]byte, perm os.FileMode) (err error) {
+	for path := range files {
+		if _, err := os.Lstat(path); err == nil {
+			return &os.PathError{Op: "write", Path: path, Err: os.ErrExist}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	temps := make(map[string]string, len(files))
+	defer func() {
+		for _, tmp := range temps {
+			os.Remove(tmp)
+		}
+	}()
+	for path, data := range files {
+		tmp, err := writeTemp(path, data, perm, nil)
+		if err != nil {
+			return err
+		}
+		temps[path] = tmp
+	}
+
+	var linked []string
+	defer func() {
+		if err != nil {
+			for _, path := range linked {
+				os.Remove(path)
+			}
+		}
+	}()
+	dirs := make(map[string]bool)
+	for path, tmp := range temps {
+		if err := os.Link(tmp, path); err != nil {
+			return err
+		}
+		linked = append(linked, path)
+		dirs[filepath.Dir(path)] = true
+	}
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update replaces the contents of the file at path with the result of
+// applying fn to its current contents. Symlinks are followed, so that the
+// target is updated and the link left in place. The file's mode and ownership
+// are preserved; to keep the ownership of a file belonging to another user,
+// which only root could give a new file, it is written in place rather than
+// atomically.
+//
+// An advisory lock is held on the file for the duration, so concurrent calls
+// to Update on the same file, even from different processes, are serialized
+// rather than losing one another's changes. It returns the result of fn.
+func Update(path string, fn func([]byte) ([]byte, error)) ([]byte, error) {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := lockFile(target)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	data, err = fn(data)
+	if err != nil {
+		return nil, err
+	}
+	return data, replace(target, data, info)
+}
+
+// lockFile opens the file at path and takes an exclusive lock on it. Since
+// Update replaces files rather than writing to them, the file may have been
+// replaced by the time the lock is granted, in which case the lock is on a
+// stale copy; lockFile then tries again with the new file.
+func lockFile(path string) (*os.File, error) {
+	for {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := lock(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		locked, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		current, err := os.Stat(path)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if os.SameFile(locked, current) {
+			return f, nil
+		}
+		f.Close()
+	}
+}
+
+// errNotOwner means that a file belongs to another user, so a new file can't
+// be given the same ownership.
+var errNotOwner = errors.New("file belongs to another user")
+
+// replace atomically replaces the file at path with data, giving it the mode
+// and ownership described by info. A file belonging to another user, as a
+// group-writable file shared between users may, is overwritten in place
+// instead, since its replacement would belong to the wrong user.
+func replace(path string, data []byte, info os.FileInfo) error {
+	tmp, err := writeTemp(path, data, info.Mode().Perm(), info)
+	if errors.Is(err, errNotOwner) {
+		return overwrite(path, data)
+	} else if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// overwrite writes data over the contents of the existing file at path and
+// flushes it to disk. Unlike replace, it isn't atomic: readers, or the file
+// system after a crash, may see a partially written file.
+func overwrite(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeTemp writes data to a new temporary file alongside path and flushes it
+// to disk, returning its name. The file is given the mode perm and, if owner
+// is not nil, the same ownership as owner.
+func writeTemp(path string, data []byte, perm os.FileMode, owner os.FileInfo) (name string, err error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return "", err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		return "", err
+	}
+	if owner != nil {
+		if err = chown(tmp, owner); err != nil {
+			return "", fmt.Errorf("couldn't preserve ownership of %s: %w", path, err)
+		}
+	}
+	if err = tmp.Sync(); err != nil {
+		return "", err
+	}
+	return tmp.Name(), tmp.Close()
+}
+
+// syncDir flushes a directory to disk, making renames within it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		if isUnsupported(err) {
+			return nil
+		}
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !isUnsupported(err) {
+		return err
+	}
+	return nil
+}
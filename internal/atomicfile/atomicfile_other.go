@@ -0,0 +1,15 @@
+//go:build !unix
+
+package atomicfile
+
+import "os"
+
+// Advisory locks and Unix ownership aren't available on this platform, so
+// these are no-ops.
+
+func lock(*os.File) error { return nil }
+
+func chown(*os.File, os.FileInfo) error { return nil }
+
+// Directories can't be opened for syncing on all platforms.
+func isUnsupported(error) bool { return true }
@@ -0,0 +1,222 @@
+package atomicfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUpdate(t *testing.T) {
+	Convey("Update", t, func() {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "file")
+		So(os.WriteFile(path, []byte("a"), 0o640), ShouldBeNil)
+
+		Convey("replaces the contents, keeping the mode", func() {
+			_, err := Update(path, func(data []byte) ([]byte, error) {
+				return append(data, 'b'), nil
+			})
+			So(err, ShouldBeNil)
+			data, _ := os.ReadFile(path)
+			So(string(data), ShouldEqual, "ab")
+			info, _ := os.Stat(path)
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0o640))
+		})
+
+		Convey("updates the target of a symlink", func() {
+			link := filepath.Join(dir, "link")
+			So(os.Symlink(path, link), ShouldBeNil)
+			_, err := Update(link, func(data []byte) ([]byte, error) {
+				return []byte("c"), nil
+			})
+			So(err, ShouldBeNil)
+			info, _ := os.Lstat(link)
+			So(info.Mode()&os.ModeSymlink, ShouldNotEqual, 0)
+			data, _ := os.ReadFile(path)
+			So(string(data), ShouldEqual, "c")
+		})
+
+		Convey("leaves the file alone if fn fails", func() {
+			fail := errors.New("nope")
+			_, err := Update(path, func(data []byte) ([]byte, error) {
+				return nil, fail
+			})
+			So(err, ShouldEqual, fail)
+			data, _ := os.ReadFile(path)
+			So(string(data), ShouldEqual, "a")
+			entries, _ := os.ReadDir(dir)
+			So(len(entries), ShouldEqual, 1)
+		})
+
+		Convey("serializes concurrent updates", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					Update(path, func(data []byte) ([]byte, error) {
+						return append(data, 'x'), nil
+					})
+				}()
+			}
+			wg.Wait()
+			data, _ := os.ReadFile(path)
+			So(len(data), ShouldEqual, 21)
+		})
+	})
+}
+
+func TestWriteFile(t *testing.T) {
+	Convey("WriteFile", t, func() {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "file")
+
+		Convey("creates a file with the given mode", func() {
+			So(WriteFile(path, []byte("a"), Options{Perm: 0o600}), ShouldBeNil)
+			info, _ := os.Stat(path)
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0o600))
+			data, _ := os.ReadFile(path)
+			So(string(data), ShouldEqual, "a")
+		})
+
+		Convey("refuses to overwrite unless asked to", func() {
+			So(os.WriteFile(path, []byte("a"), 0o644), ShouldBeNil)
+			err := WriteFile(path, []byte("b"), Options{Perm: 0o600})
+			So(errors.Is(err, os.ErrExist), ShouldBeTrue)
+			So(WriteFile(path, []byte("b"), Options{Perm: 0o600, Overwrite: true}), ShouldBeNil)
+			data, _ := os.ReadFile(path)
+			So(string(data), ShouldEqual, "b")
+			entries, _ := os.ReadDir(dir)
+			So(len(entries), ShouldEqual, 1)
+		})
+
+		Convey("refuses to follow symlinks unless asked to", func() {
+			target := filepath.Join(dir, "target")
+			So(os.Symlink(target, path), ShouldBeNil)
+			err := WriteFile(path, []byte("a"), Options{Perm: 0o600})
+			So(errors.Is(err, ErrSymlink), ShouldBeTrue)
+			_, err = os.Stat(target)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
+
+func TestWriteFiles(t *testing.T) {
+	Convey("WriteFiles", t, func() {
+		dir := t.TempDir()
+		files := map[string][]byte{
+			filepath.Join(dir, "a"): []byte("a"),
+			filepath.Join(dir, "b"): []byte("b"),
+		}
+
+		Convey("creates every file with the given mode", func() {
+			So(WriteFiles(files, 0o600), ShouldBeNil)
+			for path, want := range files {
+				info, _ := os.Stat(path)
+				So(info.Mode().Perm(), ShouldEqual, os.FileMode(0o600))
+				data, _ := os.ReadFile(path)
+				So(data, ShouldResemble, want)
+			}
+		})
+
+		Convey("creates none of them if one can't be written", func() {
+			files[filepath.Join(dir, "missing", "c")] = []byte("c")
+			So(WriteFiles(files, 0o600), ShouldNotBeNil)
+			entries, _ := os.ReadDir(dir)
+			So(entries, ShouldBeEmpty)
+		})
+
+		Convey("creates none of them if one exists", func() {
+			So(os.WriteFile(filepath.Join(dir, "b"), []byte("old"), 0o644), ShouldBeNil)
+			err := WriteFiles(files, 0o600)
+			So(errors.Is(err, os.ErrExist), ShouldBeTrue)
+			entries, _ := os.ReadDir(dir)
+			So(len(entries), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestReplaceDir(t *testing.T) {
+	Convey("ReplaceDir", t, func() {
+		parent := t.TempDir()
+		dir := filepath.Join(parent, "secrets")
+
+		Convey("creates the directory", func() {
+			So(ReplaceDir(dir, map[string][]byte{"a": []byte("1")}, Options{Perm: 0o400}), ShouldBeNil)
+			info, _ := os.Stat(dir)
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0o700))
+			info, _ = os.Stat(filepath.Join(dir, "a"))
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0o400))
+		})
+
+		Convey("replaces the contents, removing stale files and keeping the mode", func() {
+			So(ReplaceDir(dir, map[string][]byte{"stale": []byte("x")}, Options{Perm: 0o600}), ShouldBeNil)
+			So(os.Chmod(dir, 0o750), ShouldBeNil)
+			So(ReplaceDir(dir, map[string][]byte{"a": []byte("1"), "b": []byte("2")}, Options{Perm: 0o600}), ShouldBeNil)
+			entries, _ := os.ReadDir(dir)
+			So(len(entries), ShouldEqual, 3)
+			_, err := os.Stat(filepath.Join(dir, DirMarker))
+			So(err, ShouldBeNil)
+			data, _ := os.ReadFile(filepath.Join(dir, "b"))
+			So(string(data), ShouldEqual, "2")
+			info, _ := os.Stat(dir)
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0o750))
+			entries, _ = os.ReadDir(parent)
+			So(len(entries), ShouldEqual, 1)
+		})
+
+		Convey("removes the old files even from a read-only directory", func() {
+			So(ReplaceDir(dir, map[string][]byte{"stale": []byte("x")}, Options{Perm: 0o400}), ShouldBeNil)
+			So(os.Chmod(dir, 0o500), ShouldBeNil)
+			So(ReplaceDir(dir, map[string][]byte{"a": []byte("1")}, Options{Perm: 0o400}), ShouldBeNil)
+			info, _ := os.Stat(dir)
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0o500))
+			entries, _ := os.ReadDir(parent)
+			So(len(entries), ShouldEqual, 1)
+			So(os.Chmod(dir, 0o700), ShouldBeNil)
+		})
+
+		Convey("refuses to replace a directory it didn't write unless asked to", func() {
+			So(os.Mkdir(dir, 0o700), ShouldBeNil)
+			So(os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0o600), ShouldBeNil)
+			err := ReplaceDir(dir, map[string][]byte{"a": nil}, Options{Perm: 0o400})
+			So(errors.Is(err, ErrNotOwnDir), ShouldBeTrue)
+			data, _ := os.ReadFile(filepath.Join(dir, "notes.txt"))
+			So(string(data), ShouldEqual, "x")
+
+			So(ReplaceDir(dir, map[string][]byte{"a": nil}, Options{Perm: 0o400, Overwrite: true}), ShouldBeNil)
+			_, err = os.Stat(filepath.Join(dir, "notes.txt"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("refuses to replace a directory holding anything but files", func() {
+			So(os.MkdirAll(filepath.Join(dir, "sub"), 0o700), ShouldBeNil)
+			So(ReplaceDir(dir, map[string][]byte{"a": nil}, Options{Perm: 0o400}), ShouldNotBeNil)
+			_, err := os.Stat(filepath.Join(dir, "sub"))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("can swap directories without an atomic exchange", func() {
+			other := filepath.Join(parent, "other")
+			So(os.Mkdir(dir, 0o700), ShouldBeNil)
+			So(os.Mkdir(other, 0o750), ShouldBeNil)
+			So(renameAside(other, dir), ShouldBeNil)
+			info, _ := os.Stat(dir)
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0o750))
+			info, _ = os.Stat(other)
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0o700))
+		})
+
+		Convey("refuses invalid file names", func() {
+			So(ReplaceDir(dir, map[string][]byte{"../a": nil}, Options{Perm: 0o400}), ShouldNotBeNil)
+			So(ReplaceDir(dir, map[string][]byte{"..": nil}, Options{Perm: 0o400}), ShouldNotBeNil)
+			So(ReplaceDir(dir, map[string][]byte{DirMarker: nil}, Options{Perm: 0o400}), ShouldNotBeNil)
+			_, err := os.Stat(dir)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package atomicfile
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func lock(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if !errors.Is(err, syscall.EINTR) {
+			return err
+		}
+	}
+}
+
+// chown gives f the ownership described by info. Only root can give files
+// away, so a failure to do so is ignored if f already belongs to the same
+// user, which is what happens when users update their own files, and reported
+// as errNotOwner if it doesn't.
+func chown(f *os.File, info os.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	err := f.Chown(int(st.Uid), int(st.Gid))
+	if errors.Is(err, syscall.EPERM) {
+		if int(st.Uid) == os.Geteuid() {
+			return nil
+		}
+		return errNotOwner
+	}
+	return err
+}
+
+func isUnsupported(err error) bool {
+	return errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOTSUP)
+}
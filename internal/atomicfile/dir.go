@@ -0,0 +1,184 @@
+package atomicfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotOwnDir is returned by ReplaceDir when asked to replace a directory
+// it didn't write, without Options.Overwrite.
+var ErrNotOwnDir = errors.New("refusing to replace a directory that holds other files")
+
+// DirMarker is the file ReplaceDir writes into each directory it makes, to
+// recognize the directory as its own the next time.
+const DirMarker = ".ejson-dir"
+
+// dirMarkerContents explains the DirMarker to anyone who finds it.
+const dirMarkerContents = "This directory is replaced by ejson each time it is written.\n"
+
+// ReplaceDir atomically replaces the directory at path with one holding the
+// given files, each with the mode opts.Perm, so that readers see either the
+// old files or all of the new ones, and files that aren't in the new set are
+// gone. The directory is created, with mode 0700, if it doesn't exist;
+// otherwise its mode and (where possible) ownership are kept.
+//
+// The new directory is built alongside path and then renamed into place. On
+// Linux, it is exchanged with the old one in a single step; elsewhere, the old
+// directory is briefly moved aside first. File names may not contain path
+// separators. So that a mistyped path can't wipe out something else, an
+// existing directory is only replaced if it is empty or holds the DirMarker
+// written by an earlier call, unless opts.Overwrite is set, and never if it
+// holds anything but regular files. opts.FollowSymlinks is ignored: path may
+// not be a symlink.
+func ReplaceDir(path string, files map[string][]byte, opts Options) (err error) {
+	for name := range files {
+		if name == "" || name == "." || name == ".." || name == DirMarker || strings.ContainsAny(name, "/\x00") || name != filepath.Base(name) {
+			return fmt.Errorf("invalid file name %q", name)
+		}
+	}
+
+	dirPerm := os.FileMode(0o700)
+	info, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+		info = nil
+	case err != nil:
+		return err
+	case info.Mode()&os.ModeSymlink != 0:
+		return &os.PathError{Op: "replace", Path: path, Err: ErrSymlink}
+	case !info.IsDir():
+		return &os.PathError{Op: "replace", Path: path, Err: errors.New("not a directory")}
+	default:
+		if err := checkReplaceable(path, opts.Overwrite); err != nil {
+			return err
+		}
+		dirPerm = info.Mode().Perm()
+	}
+
+	parent, base := filepath.Split(filepath.Clean(path))
+	if parent == "" {
+		parent = "."
+	}
+	staging, err := os.MkdirTemp(parent, "."+base+".tmp*")
+	if err != nil {
+		return err
+	}
+	// Whether or not the exchange happens, staging ends up holding secrets
+	// that mustn't be left behind, so failing to remove it is an error.
+	defer func() {
+		if rmErr := removeDir(staging); rmErr != nil && err == nil {
+			err = fmt.Errorf("replaced %s, but couldn't remove its old files from %s: %w", path, staging, rmErr)
+		}
+	}()
+
+	marked := make(map[string][]byte, len(files)+1)
+	for name, data := range files {
+		marked[name] = data
+	}
+	marked[DirMarker] = []byte(dirMarkerContents)
+	if err := fillDir(staging, marked, opts.Perm, dirPerm, info); err != nil {
+		return err
+	}
+	if info == nil {
+		err = os.Rename(staging, path)
+	} else {
+		// Afterwards, staging holds the old directory, which is removed.
+		err = exchange(staging, path)
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(parent)
+}
+
+// checkReplaceable reports an error unless the directory at path holds only
+// regular files, and is empty, was written by ReplaceDir, or may be
+// overwritten anyway.
+func checkReplaceable(path string, overwrite bool) error {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	marked := false
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			return fmt.Errorf("refusing to replace %s: %s is not a regular file", path, entry.Name())
+		}
+		marked = marked || entry.Name() == DirMarker
+	}
+	if len(entries) > 0 && !marked && !overwrite {
+		return &os.PathError{Op: "replace", Path: path, Err: ErrNotOwnDir}
+	}
+	return nil
+}
+
+// fillDir writes files into the empty directory dir and flushes them to
+// disk, then gives dir the mode dirPerm and, if owner is not nil, the same
+// ownership as owner.
+func fillDir(dir string, files map[string][]byte, perm, dirPerm os.FileMode, owner os.FileInfo) error {
+	for name, data := range files {
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		if err == nil {
+			err = f.Chmod(perm)
+		}
+		if err == nil {
+			err = f.Sync()
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Chmod(dirPerm); err != nil {
+		return err
+	}
+	if owner != nil {
+		if err := chown(d, owner); err != nil {
+			return fmt.Errorf("couldn't preserve ownership of %s: %w", dir, err)
+		}
+	}
+	if err := d.Sync(); err != nil && !isUnsupported(err) {
+		return err
+	}
+	return nil
+}
+
+// removeDir removes a directory of files, first making it writable, since
+// the old directory may have been read-only. It does nothing if the
+// directory doesn't exist.
+func removeDir(dir string) error {
+	if err := os.Chmod(dir, 0o700); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// renameAside swaps the directories at a and b with three renames, for
+// platforms or file systems that can't exchange them atomically. For a
+// moment, nothing is at b.
+func renameAside(a, b string) error {
+	aside := a + ".old"
+	if err := os.Rename(b, aside); err != nil {
+		return err
+	}
+	if err := os.Rename(a, b); err != nil {
+		os.Rename(aside, b)
+		return err
+	}
+	return os.Rename(aside, a)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package atomicfile
+
+// exchange swaps the directories at a and b.
+func exchange(a, b string) error {
+	return renameAside(a, b)
+}
@@ -0,0 +1,139 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// SignatureField is the key at which an EJSON document may store a signature
+// over the rest of the document. Like the PolicyField, its contents are never
+// encrypted.
+const SignatureField = "_signature"
+
+// Canonicalize returns a canonical form of a JSON document, suitable for
+// signing: object members sorted by key, no insignificant whitespace, and
+// strings escaped uniformly. Numbers are kept as written. Top-level members
+// whose keys are in omit are left out. Documents with duplicate keys are
+// rejected, since they could be read in more than one way.
+func Canonicalize(data []byte, omit ...string) ([]byte, error) {
+	if err := validate(data); err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var (
+		buf      bytes.Buffer
+		location path
+	)
+	if err := canonicalValue(dec, &buf, &location, omit); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func canonicalValue(dec *json.Decoder, buf *bytes.Buffer, location *path, omit []string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			location.pushArray()
+			defer location.pop()
+			buf.WriteByte('[')
+			for i := 0; dec.More(); i++ {
+				if i > 0 {
+					buf.WriteByte(',')
+					location.nextIndex()
+				}
+				if err := canonicalValue(dec, buf, location, nil); err != nil {
+					return err
+				}
+			}
+			_, err := dec.Token()
+			buf.WriteByte(']')
+			return err
+		}
+		location.pushObject()
+		defer location.pop()
+		return canonicalObject(dec, buf, location, omit)
+	default:
+		return writeCanonicalScalar(buf, tok)
+	}
+}
+
+func canonicalObject(dec *json.Decoder, buf *bytes.Buffer, location *path, omit []string) error {
+	members := make(map[string][]byte)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		location.setKey(key)
+		if _, dup := members[key]; dup {
+			return fmt.Errorf("duplicate key %s", location)
+		}
+		var value bytes.Buffer
+		if err := canonicalValue(dec, &value, location, nil); err != nil {
+			return err
+		}
+		members[key] = value.Bytes()
+	}
+	if _, err := dec.Token(); err != nil && err != io.EOF {
+		return err
+	}
+	for _, key := range omit {
+		delete(members, key)
+	}
+
+	keys := make([]string, 0, len(members))
+	for key := range members {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeCanonicalString(buf, key); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		buf.Write(members[key])
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// writeCanonicalScalar writes a string, number, boolean or null token, as
+// read by a json.Decoder with UseNumber set.
+func writeCanonicalScalar(buf *bytes.Buffer, tok json.Token) error {
+	switch tok := tok.(type) {
+	case string:
+		return writeCanonicalString(buf, tok)
+	case json.Number:
+		buf.WriteString(tok.String())
+	case bool:
+		fmt.Fprint(buf, tok)
+	case nil:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+func writeCanonicalString(buf *bytes.Buffer, s string) error {
+	var enc bytes.Buffer
+	e := json.NewEncoder(&enc)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(s); err != nil {
+		return err
+	}
+	buf.Write(bytes.TrimSuffix(enc.Bytes(), []byte("\n")))
+	return nil
+}
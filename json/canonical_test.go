@@ -0,0 +1,76 @@
+package json
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCanonicalize(t *testing.T) {
+	Convey("Canonicalize", t, func() {
+		Convey("sorts keys and strips whitespace", func() {
+			out, err := Canonicalize([]byte("{\n  \"b\": [1, 2.50, {\"d\": null, \"c\": true}],\n  \"a\": \"x\"\n}\n"))
+			So(err, ShouldBeNil)
+			So(string(out), ShouldEqual, `{"a":"x","b":[1,2.50,{"c":true,"d":null}]}`)
+		})
+
+		Convey("escapes strings uniformly", func() {
+			out, err := Canonicalize([]byte(`{"k": "A<>&\/\n"}`))
+			So(err, ShouldBeNil)
+			So(string(out), ShouldEqual, `{"k":"A<>&/\n"}`)
+		})
+
+		Convey("omits the given top-level keys only", func() {
+			out, err := Canonicalize([]byte(`{"_signature": 1, "a": {"_signature": 2}}`), SignatureField)
+			So(err, ShouldBeNil)
+			So(string(out), ShouldEqual, `{"a":{"_signature":2}}`)
+		})
+
+		Convey("rejects duplicate keys", func() {
+			_, err := Canonicalize([]byte(`{"a": [{"b": 1, "b": 2}]}`))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "duplicate key a[0].b")
+		})
+	})
+}
+
+func TestSetField(t *testing.T) {
+	Convey("SetField", t, func() {
+		Convey("replaces an existing value in place", func() {
+			out, err := SetField([]byte("{\n  \"a\": 1,\n  \"k\": {\"x\": 1},\n  \"b\": 2\n}\n"), "k", []byte(`"v"`))
+			So(err, ShouldBeNil)
+			So(string(out), ShouldEqual, "{\n  \"a\": 1,\n  \"k\": \"v\",\n  \"b\": 2\n}\n")
+		})
+
+		Convey("appends a new member laid out like the first", func() {
+			out, err := SetField([]byte("{\n  \"a\" : 1,\n  \"b\": [2]\n}\n"), "k", []byte(`"v"`))
+			So(err, ShouldBeNil)
+			So(string(out), ShouldEqual, "{\n  \"a\" : 1,\n  \"b\": [2],\n  \"k\" : \"v\"\n}\n")
+		})
+
+		Convey("adds a member to an empty object", func() {
+			out, err := SetField([]byte(`{}`), "k", []byte(`"v"`))
+			So(err, ShouldBeNil)
+			So(string(out), ShouldEqual, `{"k": "v"}`)
+		})
+
+		Convey("rejects documents that aren't objects", func() {
+			_, err := SetField([]byte(`["a"]`), "k", []byte(`"v"`))
+			So(err, ShouldEqual, errNotObject)
+		})
+	})
+}
+
+func TestLeaves(t *testing.T) {
+	Convey("Leaves lists every leaf in document order", t, func() {
+		leaves, err := Leaves([]byte(`{"b": [1, {"x": "A"}], "a": {}, "c": [], "d": null}`))
+		So(err, ShouldBeNil)
+		So(leaves, ShouldResemble, []Leaf{
+			{Path: "b[0]", Value: []byte(`1`)},
+			{Path: "b[1].x", Value: []byte(`"A"`)},
+			{Path: "a", Value: []byte(`{}`)},
+			{Path: "c", Value: []byte(`[]`)},
+			{Path: "d", Value: []byte(`null`)},
+		})
+	})
+}
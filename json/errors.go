@@ -0,0 +1,175 @@
+package json
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/dustin/gojson"
+)
+
+// contextRadius is the number of bytes either side of the offending character
+// included in SyntaxError.Context.
+const contextRadius = 24
+
+// SyntaxError describes a malformed EJSON document. It locates the problem
+// both as a byte offset and as a 1-based line and column (counted in runes),
+// and carries a short snippet of the surrounding line.
+//
+// Since the document being parsed very likely contains plaintext secrets, the
+// contents of string values are never included in the error: they are masked
+// out of Context, and if the error occurred inside a string literal, Char is
+// left as zero and the message doesn't mention the offending character.
+type SyntaxError struct {
+	Offset  int64  // byte offset of the offending character
+	Line    int    // 1-based line number
+	Column  int    // 1-based column number, in runes
+	Char    byte   // the offending character, or 0 (see above)
+	Context string // redacted snippet of the offending line
+	Msg     string // description of the error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("invalid json: line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// newSyntaxError builds a SyntaxError for a failure at data[offset] (or at
+// EOF, when offset == len(data)). scanErr is the error reported by the
+// scanner, and inString indicates that the offending character was inside a
+// string literal.
+func newSyntaxError(data []byte, offset int, scanErr error, inString bool) *SyntaxError {
+	lineStart := bytes.LastIndexByte(data[:offset], '\n') + 1
+	e := &SyntaxError{
+		Offset:  int64(offset),
+		Line:    bytes.Count(data[:offset], []byte{'\n'}) + 1,
+		Column:  utf8.RuneCount(data[lineStart:offset]) + 1,
+		Context: redactedContext(data, offset),
+	}
+
+	switch {
+	case offset >= len(data):
+		e.Msg = "unexpected end of JSON input"
+	case inString:
+		e.Msg = "invalid character in string literal"
+	default:
+		e.Char = data[offset]
+		if se, ok := scanErr.(*json.SyntaxError); ok {
+			e.Msg = se.Error()
+		} else {
+			e.Msg = fmt.Sprintf("invalid character %q", data[offset])
+		}
+	}
+	return e
+}
+
+// validate checks that data is a single well-formed JSON value, returning a
+// *SyntaxError if it isn't.
+func validate(data []byte) error {
+	var scanner json.Scanner
+	scanner.Reset()
+	inString := false
+	for i, c := range data {
+		switch scanner.Step(&scanner, int(c)) {
+		case json.ScanBeginLiteral:
+			inString = c == '"'
+		case json.ScanContinue:
+		case json.ScanError:
+			return newSyntaxError(data, i, scanner.Err, inString)
+		case json.ScanEnd:
+			return nil
+		default:
+			inString = false
+		}
+	}
+	if scanner.EOF() == json.ScanError {
+		return newSyntaxError(data, len(data), scanner.Err, inString)
+	}
+	return nil
+}
+
+// redactedContext returns the part of the line containing data[offset] that
+// lies within contextRadius bytes of it. Object keys are shown as-is, but the
+// contents of every other string literal are replaced with "...".
+func redactedContext(data []byte, offset int) string {
+	lineStart := bytes.LastIndexByte(data[:offset], '\n') + 1
+	lineEnd := len(data)
+	if i := bytes.IndexByte(data[offset:], '\n'); i >= 0 {
+		lineEnd = offset + i
+	}
+	from := max(lineStart, offset-contextRadius)
+	to := min(lineEnd, offset+contextRadius)
+
+	var (
+		out      []byte
+		inString bool
+		isKey    bool
+		esc      bool
+	)
+	for i := 0; i < to; i++ {
+		c := data[i]
+		visible := i >= from
+		if !inString {
+			if c == '"' {
+				inString = true
+				isKey = stringIsKey(data, i)
+				if visible && !isKey {
+					out = append(out, `"...`...)
+				}
+			}
+			if visible && (c != '"' || isKey) {
+				out = append(out, c)
+			}
+			continue
+		}
+		switch {
+		case esc:
+			esc = false
+		case c == '\\':
+			esc = true
+		case c == '"':
+			inString = false
+		}
+		if i == from && !isKey && inString {
+			out = append(out, "..."...)
+		}
+		if visible && (isKey || !inString) {
+			out = append(out, c)
+		}
+	}
+	return string(bytes.TrimRight(out, "\r"))
+}
+
+// stringIsKey reports whether the string literal opening at data[start] is
+// followed by a colon, i.e. whether it is an object key.
+func stringIsKey(data []byte, start int) bool {
+	esc := false
+	for i := start + 1; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case esc:
+			esc = false
+		case c == '\\':
+			esc = true
+		case c == '"':
+			rest := bytes.TrimLeft(data[i+1:], " \t\r\n")
+			return len(rest) > 0 && rest[0] == ':'
+		}
+	}
+	return false
+}
+
+// ValueError records a failure to process (i.e. encrypt or decrypt) a single
+// value in a document. Path locates the value, e.g. `database.password` or
+// `hosts[0]`.
+type ValueError struct {
+	Path string
+	Err  error
+}
+
+func (e *ValueError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *ValueError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,113 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// errNotObject means that a document which should have been a JSON object
+// was some other kind of value.
+var errNotObject = errors.New("document is not a JSON object")
+
+// member locates a top-level member of a JSON object within the document.
+type member struct {
+	key        string
+	keyStart   int
+	keyEnd     int
+	valueStart int
+	valueEnd   int
+}
+
+// SetField sets the value of a top-level member of a JSON object, returning
+// the modified document. The value must be valid JSON. If the member exists,
+// its value is replaced in place; otherwise the member is added at the end of
+// the object, laid out like the first member. The rest of the document is
+// left exactly as it was, for the same reason the Walker works on the raw
+// text.
+func SetField(data []byte, key string, value []byte) ([]byte, error) {
+	if err := validate(data); err != nil {
+		return nil, err
+	}
+	members, open, closing, err := topLevelMembers(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, m := range members {
+		if m.key == key {
+			buf.Write(data[:m.valueStart])
+			buf.Write(value)
+			buf.Write(data[m.valueEnd:])
+			return buf.Bytes(), nil
+		}
+	}
+
+	quoted, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		buf.Write(data[:closing])
+		buf.Write(quoted)
+		buf.WriteString(": ")
+		buf.Write(value)
+		buf.Write(data[closing:])
+		return buf.Bytes(), nil
+	}
+	first, last := members[0], members[len(members)-1]
+	buf.Write(data[:last.valueEnd])
+	buf.WriteByte(',')
+	buf.Write(data[open:first.keyStart])
+	buf.Write(quoted)
+	buf.Write(data[first.keyEnd:first.valueStart])
+	buf.Write(value)
+	buf.Write(data[last.valueEnd:])
+	return buf.Bytes(), nil
+}
+
+// topLevelMembers locates the members of the JSON object in data, along with
+// the offsets just after its opening brace and of its closing brace.
+func topLevelMembers(data []byte) (members []member, open, closing int, err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if tok != json.Delim('{') {
+		return nil, 0, 0, errNotObject
+	}
+	open = int(dec.InputOffset())
+	for dec.More() {
+		var m member
+		m.keyStart = skipSeparators(data, int(dec.InputOffset()))
+		if tok, err = dec.Token(); err != nil {
+			return nil, 0, 0, err
+		}
+		m.key = tok.(string)
+		m.keyEnd = int(dec.InputOffset())
+
+		var raw json.RawMessage
+		if err = dec.Decode(&raw); err != nil {
+			return nil, 0, 0, err
+		}
+		m.valueEnd = int(dec.InputOffset())
+		m.valueStart = m.valueEnd - len(raw)
+		members = append(members, m)
+	}
+	if _, err = dec.Token(); err != nil {
+		return nil, 0, 0, err
+	}
+	closing = int(dec.InputOffset()) - 1
+	return members, open, closing, nil
+}
+
+// skipSeparators returns the offset of the first byte at or after i that is
+// neither whitespace nor a comma.
+func skipSeparators(data []byte, i int) int {
+	for i < len(data) && bytes.IndexByte([]byte(" \t\r\n,"), data[i]) >= 0 {
+		i++
+	}
+	return i
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+
+	"github.com/Shopify/ejson/crypto"
 )
 
 const (
@@ -23,40 +25,40 @@ var ErrPublicKeyInvalid = errors.New("public key has invalid format")
 // ExtractPublicKey finds the _public_key value in an EJSON document and
 // parses it into a key usable with the crypto library.
 func ExtractPublicKey(data []byte) (key [32]byte, err error) {
-	var (
-		obj map[string]interface{}
-		ks  string
-		ok  bool
-		bs  []byte
-	)
-	err = json.Unmarshal(data, &obj)
-	if err != nil {
+	var obj map[string]interface{}
+	if err = validate(data); err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &obj); err != nil {
 		return
 	}
 	k, ok := obj[PublicKeyField]
 	if !ok {
-		goto missing
+		return key, ErrPublicKeyMissing
 	}
-	ks, ok = k.(string)
+	ks, ok := k.(string)
 	if !ok {
-		goto invalid
+		return key, ErrPublicKeyInvalid
 	}
-	if len(ks) != 64 {
-		goto invalid
+	return ParsePublicKey(ks)
+}
+
+// ParsePublicKey parses a public key as it may appear in the PublicKeyField:
+// either 64 hex digits, or an age X25519 recipient (age1...).
+func ParsePublicKey(ks string) (key [32]byte, err error) {
+	if crypto.IsAgeRecipient(ks) {
+		if key, err = crypto.ParseAgeRecipient(ks); err != nil {
+			return key, ErrPublicKeyInvalid
+		}
+		return key, nil
 	}
-	bs, err = hex.DecodeString(ks)
-	if err != nil {
-		goto invalid
+	if len(ks) != 64 {
+		return key, ErrPublicKeyInvalid
 	}
-	if len(bs) != 32 {
-		goto invalid
+	bs, err := hex.DecodeString(ks)
+	if err != nil || len(bs) != 32 {
+		return key, ErrPublicKeyInvalid
 	}
 	copy(key[:], bs)
-	return
-missing:
-	err = ErrPublicKeyMissing
-	return
-invalid:
-	err = ErrPublicKeyInvalid
-	return
+	return key, nil
 }
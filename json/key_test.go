@@ -15,6 +15,14 @@ func TestKeyExtraction(t *testing.T) {
 			So(err, ShouldBeNil)
 			So(key, ShouldResemble, expected)
 		})
+		Convey("succeeds when the key is an age recipient", func() {
+			in := `{"_public_key": "age1d4um0egqw0j7v6j9s8ks30cangpcqmxyvjx0l6md7ud4wa09avyqfe3zj5"}`
+			expected, err := ExtractPublicKey([]byte(`{"_public_key": "6d79b7e50073e5e66a4581ed08bf1d9a03806cc4648cffeb6df71b5775e5eb08"}`))
+			So(err, ShouldBeNil)
+			key, err := ExtractPublicKey([]byte(in))
+			So(err, ShouldBeNil)
+			So(key, ShouldResemble, expected)
+		})
 		Convey("fails", func() {
 			Convey("if key is too short", func() {
 				in := `{"_public_key": "6d79b7e50073e5e66a4581ed08bf1d9a03806cc4648cffeb6df71b5775e5eb0"}`
@@ -28,6 +36,12 @@ func TestKeyExtraction(t *testing.T) {
 				So(err, ShouldEqual, ErrPublicKeyInvalid)
 			})
 
+			Convey("or if key is an invalid age recipient", func() {
+				in := `{"_public_key": "age1d4um0egqw0j7v6j9s8ks30cangpcqmxyvjx0l6md7ud4wa09avyqfe3zj6"}`
+				_, err := ExtractPublicKey([]byte(in))
+				So(err, ShouldEqual, ErrPublicKeyInvalid)
+			})
+
 			Convey("or if key is missing", func() {
 				in := `{"nope": "dunno"}`
 				_, err := ExtractPublicKey([]byte(in))
@@ -0,0 +1,82 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Leaf is a value in a document that has no children: a string, number,
+// boolean or null, or an empty object or array.
+type Leaf struct {
+	// Path locates the leaf in the document, as in Value.Path.
+	Path string
+	// Value is the canonical JSON text of the leaf (see Canonicalize).
+	Value []byte
+}
+
+// Leaves returns every leaf of a JSON document, in the order they appear.
+func Leaves(data []byte) ([]Leaf, error) {
+	if err := validate(data); err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var (
+		leaves   []Leaf
+		location path
+	)
+	if err := collectLeaves(dec, &location, &leaves); err != nil {
+		return nil, err
+	}
+	return leaves, nil
+}
+
+func collectLeaves(dec *json.Decoder, location *path, leaves *[]Leaf) error {
+	at := location.String()
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		var buf bytes.Buffer
+		if err := writeCanonicalScalar(&buf, tok); err != nil {
+			return err
+		}
+		*leaves = append(*leaves, Leaf{Path: at, Value: buf.Bytes()})
+		return nil
+	}
+
+	if delim == '[' {
+		location.pushArray()
+	} else {
+		location.pushObject()
+	}
+	n := 0
+	for ; dec.More(); n++ {
+		if delim == '[' && n > 0 {
+			location.nextIndex()
+		}
+		if delim == '{' {
+			if tok, err = dec.Token(); err != nil {
+				return err
+			}
+			location.setKey(tok.(string))
+		}
+		if err := collectLeaves(dec, location, leaves); err != nil {
+			return err
+		}
+	}
+	location.pop()
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	if n == 0 {
+		empty := "{}"
+		if delim == '[' {
+			empty = "[]"
+		}
+		*leaves = append(*leaves, Leaf{Path: at, Value: []byte(empty)})
+	}
+	return nil
+}
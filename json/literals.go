@@ -0,0 +1,58 @@
+package json
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// EncryptLiteralsField is the key at which an EJSON document may opt in to
+// having numbers, booleans and nulls encrypted as well as strings. Its value
+// is either true, to encrypt every such literal in the document, or an array
+// of paths (as in Value.Path), to encrypt only the literals at or below those
+// paths. For example:
+//
+//	"_encrypt_literals": ["max_connections", "features"]
+const EncryptLiteralsField = "_encrypt_literals"
+
+// ErrEncryptLiteralsInvalid means that the EncryptLiteralsField key was found,
+// but its value was neither a boolean nor an array of strings.
+var ErrEncryptLiteralsInvalid = errors.New("_encrypt_literals must be a boolean or an array of paths")
+
+// LiteralSelector reports whether the number, boolean or null at the given
+// path should be encrypted.
+type LiteralSelector func(path string) bool
+
+// ExtractLiteralSelector reads the EncryptLiteralsField value from an EJSON
+// document. If the field is absent, the returned LiteralSelector selects
+// nothing.
+func ExtractLiteralSelector(data []byte) (LiteralSelector, error) {
+	var obj map[string]json.RawMessage
+	if err := validate(data); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	raw, ok := obj[EncryptLiteralsField]
+	if !ok {
+		return func(string) bool { return false }, nil
+	}
+
+	var all bool
+	if err := json.Unmarshal(raw, &all); err == nil {
+		return func(string) bool { return all }, nil
+	}
+	var paths []string
+	if err := json.Unmarshal(raw, &paths); err != nil {
+		return nil, ErrEncryptLiteralsInvalid
+	}
+	return func(path string) bool {
+		for _, p := range paths {
+			if path == p || strings.HasPrefix(path, p+".") || strings.HasPrefix(path, p+"[") {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
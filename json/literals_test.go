@@ -0,0 +1,64 @@
+package json
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLiteralSelector(t *testing.T) {
+	Convey("ExtractLiteralSelector", t, func() {
+		Convey("selects nothing when the field is absent", func() {
+			sel, err := ExtractLiteralSelector([]byte(`{"a": 1}`))
+			So(err, ShouldBeNil)
+			So(sel("a"), ShouldBeFalse)
+		})
+
+		Convey("selects everything when the field is true", func() {
+			sel, err := ExtractLiteralSelector([]byte(`{"_encrypt_literals": true, "a": 1}`))
+			So(err, ShouldBeNil)
+			So(sel("a"), ShouldBeTrue)
+			So(sel("b[0].c"), ShouldBeTrue)
+		})
+
+		Convey("selects listed paths and their children", func() {
+			sel, err := ExtractLiteralSelector([]byte(`{"_encrypt_literals": ["a", "b.c"]}`))
+			So(err, ShouldBeNil)
+			So(sel("a"), ShouldBeTrue)
+			So(sel("a[2]"), ShouldBeTrue)
+			So(sel("b.c.d"), ShouldBeTrue)
+			So(sel("b.cd"), ShouldBeFalse)
+			So(sel("ab"), ShouldBeFalse)
+		})
+
+		Convey("fails if the field has the wrong type", func() {
+			_, err := ExtractLiteralSelector([]byte(`{"_encrypt_literals": "a"}`))
+			So(err, ShouldEqual, ErrEncryptLiteralsInvalid)
+		})
+	})
+}
+
+func TestWalkerValueAction(t *testing.T) {
+	Convey("ValueAction sees literals with their paths", t, func() {
+		var (
+			mu   sync.Mutex
+			seen []string
+		)
+		walker := Walker{ValueAction: func(v Value) (Value, error) {
+			if v.Literal {
+				mu.Lock()
+				defer mu.Unlock()
+				seen = append(seen, v.Path+"="+string(v.Data))
+				return Value{Data: []byte("E")}, nil
+			}
+			return Value{Data: []byte("500"), Literal: true}, nil
+		}}
+		out, err := walker.Walk([]byte(`{"a": 1, "_b": 2, "c": [true, null], "d": "x"}`))
+		So(err, ShouldBeNil)
+		So(string(out), ShouldEqual, `{"a": "E", "_b": 2, "c": ["E", "E"], "d": 500}`)
+		So(seen, ShouldContain, "a=1")
+		So(seen, ShouldContain, "c[0]=true")
+		So(seen, ShouldContain, "c[1]=null")
+	})
+}
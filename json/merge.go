@@ -0,0 +1,201 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Origin records which of the documents given to Merge a value in the merged
+// document came from.
+type Origin struct {
+	// Path locates the value in the merged document, as in Value.Path.
+	Path string
+	// Layer is the index of the document the value came from.
+	Layer int
+}
+
+// mergeNode is a value in a document being merged. Objects keep their members
+// in order; anything else is kept as compact JSON text. Every value parsed
+// from a document also keeps its text as it appeared there. In the result of
+// Merge3, a node may instead be a conflict.
+type mergeNode struct {
+	isObject bool
+	members  []mergeMember
+	raw      []byte
+	text     []byte
+	layer    int
+	conflict *conflictNode
+}
+
+type mergeMember struct {
+	key   string
+	value *mergeNode
+}
+
+// Merge overlays a list of JSON objects, each on top of those before it, and
+// returns the result, indented with two spaces, along with the origin of each
+// value in it that isn't a non-empty object, in order. Objects are merged
+// member by member, recursively. Any other value, including an array,
+// replaces the value it is merged onto, except that null removes it, as in a
+// JSON Merge Patch (RFC 7386). Members keep the order they were first seen
+// in. Top-level members whose keys are in omit are left out of every
+// document.
+func Merge(docs [][]byte, omit ...string) ([]byte, []Origin, error) {
+	var merged *mergeNode
+	for layer, doc := range docs {
+		if err := validate(doc); err != nil {
+			return nil, nil, err
+		}
+		node, err := parseMergeNode(doc, layer)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !node.isObject {
+			return nil, nil, errNotObject
+		}
+		for _, key := range omit {
+			node.remove(key)
+		}
+		if merged == nil {
+			merged = node
+		} else {
+			merged = mergePatch(merged, node)
+		}
+	}
+	if merged == nil {
+		return nil, nil, fmt.Errorf("no documents to merge")
+	}
+
+	var compact, indented bytes.Buffer
+	if err := merged.write(&compact); err != nil {
+		return nil, nil, err
+	}
+	if err := json.Indent(&indented, compact.Bytes(), "", "  "); err != nil {
+		return nil, nil, err
+	}
+	indented.WriteByte('\n')
+
+	var (
+		origins  []Origin
+		location path
+	)
+	merged.origins(&location, &origins)
+	return indented.Bytes(), origins, nil
+}
+
+func parseMergeNode(data []byte, layer int) (*mergeNode, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, data); err != nil {
+			return nil, err
+		}
+		return &mergeNode{raw: compact.Bytes(), text: data, layer: layer}, nil
+	}
+
+	node := &mergeNode{isObject: true, text: data, layer: layer}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		value, err := parseMergeNode(raw, layer)
+		if err != nil {
+			return nil, err
+		}
+		node.set(tok.(string), value)
+	}
+	return node, nil
+}
+
+// mergePatch applies patch to target, returning the result.
+func mergePatch(target, patch *mergeNode) *mergeNode {
+	if !patch.isObject {
+		return patch
+	}
+	if target == nil || !target.isObject {
+		target = &mergeNode{isObject: true, layer: patch.layer}
+	}
+	for _, m := range patch.members {
+		if !m.value.isObject && string(m.value.raw) == "null" {
+			target.remove(m.key)
+		} else {
+			target.set(m.key, mergePatch(target.get(m.key), m.value))
+		}
+	}
+	return target
+}
+
+func (n *mergeNode) get(key string) *mergeNode {
+	for _, m := range n.members {
+		if m.key == key {
+			return m.value
+		}
+	}
+	return nil
+}
+
+func (n *mergeNode) set(key string, value *mergeNode) {
+	for i, m := range n.members {
+		if m.key == key {
+			n.members[i].value = value
+			return
+		}
+	}
+	n.members = append(n.members, mergeMember{key: key, value: value})
+}
+
+func (n *mergeNode) remove(key string) {
+	for i, m := range n.members {
+		if m.key == key {
+			n.members = append(n.members[:i], n.members[i+1:]...)
+			return
+		}
+	}
+}
+
+func (n *mergeNode) write(buf *bytes.Buffer) error {
+	if !n.isObject {
+		buf.Write(n.raw)
+		return nil
+	}
+	buf.WriteByte('{')
+	for i, m := range n.members {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeCanonicalString(buf, m.key); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		if err := m.value.write(buf); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// origins appends the origin of each leaf under n. An array counts as a
+// single leaf, since it always comes from a single document.
+func (n *mergeNode) origins(location *path, origins *[]Origin) {
+	if !n.isObject || len(n.members) == 0 {
+		*origins = append(*origins, Origin{Path: location.String(), Layer: n.layer})
+		return
+	}
+	location.pushObject()
+	defer location.pop()
+	for _, m := range n.members {
+		location.setKey(m.key)
+		m.value.origins(location, origins)
+	}
+}
@@ -0,0 +1,413 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/Shopify/ejson/crypto"
+)
+
+// The documents taken by Merge3, in the order of its arguments.
+const (
+	MergeBase = iota
+	MergeOurs
+	MergeTheirs
+)
+
+// Conflict markers, as git writes them.
+const (
+	conflictOurs   = "<<<<<<< ours\n"
+	conflictSep    = "=======\n"
+	conflictTheirs = ">>>>>>> theirs\n"
+)
+
+// conflictNode is a member changed differently by both sides of a three-way
+// merge. Either side is nil if that side removed the member.
+type conflictNode struct {
+	ours, theirs *mergeNode
+}
+
+// merge3 holds the state of a three-way merge.
+type merge3 struct {
+	normalize func(side int, value []byte) []byte
+	location  path
+	conflicts []string
+	// taken holds the values taken from ours or theirs because only that
+	// side changed them.
+	taken [3][]*mergeNode
+}
+
+// Merge3 merges the changes made to a JSON object in two documents, ours and
+// theirs, since their common ancestor, base, member by member. Where only one
+// side changed a value, that change is taken. Where both sides changed an
+// object, the changes to its members are merged in turn. Anything else
+// changed by both sides, including arrays, which are merged as a whole, is a
+// conflict unless both changed it to the same thing.
+//
+// If normalize is not nil, values whose JSON text differs are compared again
+// after normalizing them with it, so that, for example, values encrypted
+// separately can be compared by their plaintext. Its side argument is one of
+// MergeBase, MergeOurs or MergeTheirs.
+//
+// If only one side changed the PublicKeyField, and values encrypted by the
+// other side would be taken, the PublicKeyField is a conflict too, since the
+// merged document would otherwise hold values encrypted for two keys.
+//
+// The merged document is ours, with the changes taken from theirs spliced
+// in, so that, like the Walker, it keeps ours' layout and order. Members
+// added by theirs are added after ours' members, laid out like them. Each
+// conflict is written as both sides' versions of the member, between git's
+// conflict markers, and its path is returned in conflicts.
+func Merge3(base, ours, theirs []byte, normalize func(side int, value []byte) []byte) (merged []byte, conflicts []string, err error) {
+	var nodes [3]*mergeNode
+	for side, doc := range [][]byte{base, ours, theirs} {
+		if err := validate(doc); err != nil {
+			return nil, nil, err
+		}
+		if nodes[side], err = parseMergeNode(doc, side); err != nil {
+			return nil, nil, err
+		}
+		if !nodes[side].isObject {
+			return nil, nil, errNotObject
+		}
+	}
+	// Keep the whitespace around ours, such as its final newline.
+	nodes[MergeOurs].text = ours
+
+	m := &merge3{normalize: normalize}
+	result := m.mergeObjects(nodes[MergeBase], nodes[MergeOurs], nodes[MergeTheirs])
+	m.checkPublicKey(nodes, result)
+
+	var buf bytes.Buffer
+	if err := spliceObject(&buf, result, nodes[MergeOurs], ""); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), m.conflicts, nil
+}
+
+// checkPublicKey makes the PublicKeyField of the merged document a conflict
+// if one side changed it, and encrypted values were taken from the other.
+func (m *merge3) checkPublicKey(nodes [3]*mergeNode, result *mergeNode) {
+	base, ours, theirs := nodes[MergeBase].get(PublicKeyField), nodes[MergeOurs].get(PublicKeyField), nodes[MergeTheirs].get(PublicKeyField)
+	var unchanged int
+	switch {
+	case m.same(MergeBase, base, MergeOurs, ours) && !m.same(MergeBase, base, MergeTheirs, theirs):
+		unchanged = MergeOurs
+	case m.same(MergeBase, base, MergeTheirs, theirs) && !m.same(MergeBase, base, MergeOurs, ours):
+		unchanged = MergeTheirs
+	default:
+		return
+	}
+	for _, node := range m.taken[unchanged] {
+		if node.hasBoxedMessage() {
+			result.set(PublicKeyField, &mergeNode{conflict: &conflictNode{ours: ours, theirs: theirs}})
+			m.conflicts = append([]string{PublicKeyField}, m.conflicts...)
+			return
+		}
+	}
+}
+
+// hasBoxedMessage reports whether the node holds an encrypted value.
+func (n *mergeNode) hasBoxedMessage() bool {
+	if n.isObject {
+		for _, member := range n.members {
+			if member.value.hasBoxedMessage() {
+				return true
+			}
+		}
+		return false
+	}
+	var value interface{}
+	if err := json.Unmarshal(n.raw, &value); err != nil {
+		return false
+	}
+	return hasBoxedMessage(value)
+}
+
+func hasBoxedMessage(value interface{}) bool {
+	switch value := value.(type) {
+	case string:
+		return crypto.IsBoxedMessage([]byte(value))
+	case []interface{}:
+		for _, element := range value {
+			if hasBoxedMessage(element) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mergeObjects merges the members of three objects. base may be nil if the
+// object didn't exist in the ancestor.
+func (m *merge3) mergeObjects(base, ours, theirs *mergeNode) *mergeNode {
+	if base == nil || !base.isObject {
+		base = &mergeNode{isObject: true}
+	}
+	keys := make([]string, 0, len(ours.members)+len(theirs.members))
+	for _, member := range ours.members {
+		keys = append(keys, member.key)
+	}
+	for _, member := range theirs.members {
+		if ours.get(member.key) == nil {
+			keys = append(keys, member.key)
+		}
+	}
+
+	result := &mergeNode{isObject: true, layer: MergeOurs}
+	m.location.pushObject()
+	defer m.location.pop()
+	for _, key := range keys {
+		m.location.setKey(key)
+		if value := m.mergeValues(base.get(key), ours.get(key), theirs.get(key)); value != nil {
+			result.members = append(result.members, mergeMember{key: key, value: value})
+		}
+	}
+	return result
+}
+
+// mergeValues merges one member of an object, any of whose versions may be
+// nil if the member doesn't exist in that document. It returns nil if the
+// member should be removed.
+func (m *merge3) mergeValues(base, ours, theirs *mergeNode) *mergeNode {
+	switch {
+	case m.same(MergeOurs, ours, MergeTheirs, theirs):
+		return ours
+	case m.same(MergeBase, base, MergeOurs, ours):
+		if theirs != nil {
+			m.taken[MergeTheirs] = append(m.taken[MergeTheirs], theirs)
+		}
+		return theirs
+	case m.same(MergeBase, base, MergeTheirs, theirs):
+		if ours != nil {
+			m.taken[MergeOurs] = append(m.taken[MergeOurs], ours)
+		}
+		return ours
+	case ours != nil && theirs != nil && ours.isObject && theirs.isObject:
+		return m.mergeObjects(base, ours, theirs)
+	default:
+		m.conflicts = append(m.conflicts, m.location.String())
+		return &mergeNode{conflict: &conflictNode{ours: ours, theirs: theirs}}
+	}
+}
+
+// same reports whether two versions of a value are the same.
+func (m *merge3) same(sideA int, a *mergeNode, sideB int, b *mergeNode) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if a.isObject || b.isObject {
+		if !a.isObject || !b.isObject || len(a.members) != len(b.members) {
+			return false
+		}
+		for _, member := range a.members {
+			if !m.same(sideA, member.value, sideB, b.get(member.key)) {
+				return false
+			}
+		}
+		return true
+	}
+	if bytes.Equal(a.raw, b.raw) {
+		return true
+	}
+	return m.normalize != nil && bytes.Equal(m.normalize(sideA, a.raw), m.normalize(sideB, b.raw))
+}
+
+// spliceObject writes a merged object, given the version of it in ours, from
+// whose text it takes everything that wasn't changed: the members ours kept,
+// and the layout around them. Other values are written as they appeared in
+// theirs. indent is the indentation of the line on which the object begins.
+func spliceObject(buf *bytes.Buffer, result, ours *mergeNode, indent string) error {
+	data := ours.text
+	members, open, closing, err := topLevelMembers(data)
+	if err != nil {
+		return err
+	}
+	if len(result.members) == 0 {
+		buf.Write(data[:open])
+		buf.Write(data[closing:])
+		return nil
+	}
+	if len(members) == 0 {
+		// There's no layout to follow.
+		buf.Write(data[:open-1])
+		if err := result.writeIndented(buf, indent); err != nil {
+			return err
+		}
+		buf.Write(data[closing+1:])
+		return nil
+	}
+
+	// Split the text before the first member, and between the first two,
+	// into the line break and the indentation of the next member.
+	first, last := members[0], members[len(members)-1]
+	head, memberIndent := splitIndent(data[:first.keyStart])
+	sep := append([]byte{','}, data[open:first.keyStart]...)
+	if len(members) > 1 {
+		sep = data[first.valueEnd:members[1].keyStart]
+	}
+	sep, _ = splitIndent(sep)
+	valueIndent := indent
+	if bytes.IndexByte(head, '\n') >= 0 {
+		valueIndent = string(memberIndent)
+	}
+	positions := make(map[string]member, len(members))
+	for _, pos := range members {
+		positions[pos.key] = pos
+	}
+
+	// writeMember writes one version of a member, including its key as ours
+	// wrote it, if ours has it, or else as ours' first member is laid out.
+	writeMember := func(key string, value, oursValue *mergeNode) error {
+		buf.Write(memberIndent)
+		if pos, ok := positions[key]; ok {
+			buf.Write(data[pos.keyStart:pos.valueStart])
+		} else {
+			if err := writeCanonicalString(buf, key); err != nil {
+				return err
+			}
+			buf.Write(data[first.keyEnd:first.valueStart])
+		}
+		switch {
+		case value == oursValue:
+			buf.Write(value.text)
+			return nil
+		case value.isObject && value.layer == MergeOurs && oursValue != nil && oursValue.isObject:
+			return spliceObject(buf, value, oursValue, valueIndent)
+		case value.text != nil:
+			buf.Write(value.text)
+			return nil
+		default:
+			return value.writeIndented(buf, valueIndent)
+		}
+	}
+
+	buf.Write(head)
+	afterConflict := false
+	for i, mm := range result.members {
+		if i > 0 {
+			if afterConflict {
+				// The conflict ended with its own comma and line break.
+				buf.Write(skipLineBreak(bytes.TrimPrefix(sep, []byte{','})))
+			} else {
+				buf.Write(sep)
+			}
+		}
+		afterConflict = mm.value.conflict != nil
+		if !afterConflict {
+			if err := writeMember(mm.key, mm.value, ours.get(mm.key)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+		for _, side := range []struct {
+			marker string
+			value  *mergeNode
+		}{
+			{conflictOurs, mm.value.conflict.ours},
+			{conflictSep, mm.value.conflict.theirs},
+		} {
+			buf.WriteString(side.marker)
+			if side.value == nil {
+				continue
+			}
+			if err := writeMember(mm.key, side.value, ours.get(mm.key)); err != nil {
+				return err
+			}
+			if i < len(result.members)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(conflictTheirs)
+	}
+	tail := data[last.valueEnd:]
+	if afterConflict {
+		tail = skipLineBreak(tail)
+	}
+	buf.Write(tail)
+	return nil
+}
+
+// splitIndent splits the whitespace after the last line break in b from the
+// rest of it. If b has no line break, there is no indentation.
+func splitIndent(b []byte) (rest, indent []byte) {
+	i := bytes.LastIndexByte(b, '\n')
+	if i < 0 {
+		return b, nil
+	}
+	return b[:i+1], b[i+1:]
+}
+
+// skipLineBreak drops the whitespace at the start of b up to and including
+// its first line break, if it has one.
+func skipLineBreak(b []byte) []byte {
+	i := 0
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t' || b[i] == '\r') {
+		i++
+	}
+	if i < len(b) && b[i] == '\n' {
+		return b[i+1:]
+	}
+	return b
+}
+
+// writeIndented writes the node, laid out with two-space indentation, at the
+// given level of indentation.
+func (n *mergeNode) writeIndented(buf *bytes.Buffer, indent string) error {
+	if !n.isObject {
+		return json.Indent(buf, n.raw, indent, "  ")
+	}
+	if len(n.members) == 0 {
+		buf.WriteString("{}")
+		return nil
+	}
+
+	inner := indent + "  "
+	buf.WriteString("{\n")
+	for i, member := range n.members {
+		comma := ",\n"
+		if i == len(n.members)-1 {
+			comma = "\n"
+		}
+		if member.value.conflict == nil {
+			if err := writeIndentedMember(buf, inner, member.key, member.value, comma); err != nil {
+				return err
+			}
+			continue
+		}
+		buf.WriteString(conflictOurs)
+		if err := writeIndentedMember(buf, inner, member.key, member.value.conflict.ours, comma); err != nil {
+			return err
+		}
+		buf.WriteString(conflictSep)
+		if err := writeIndentedMember(buf, inner, member.key, member.value.conflict.theirs, comma); err != nil {
+			return err
+		}
+		buf.WriteString(conflictTheirs)
+	}
+	buf.WriteString(indent + "}")
+	return nil
+}
+
+// writeIndentedMember writes one member of an object, followed by comma. It
+// writes nothing if value is nil.
+func writeIndentedMember(buf *bytes.Buffer, indent, key string, value *mergeNode, comma string) error {
+	if value == nil {
+		return nil
+	}
+	buf.WriteString(indent)
+	if err := writeCanonicalString(buf, key); err != nil {
+		return err
+	}
+	buf.WriteString(": ")
+	if err := value.writeIndented(buf, indent); err != nil {
+		return err
+	}
+	buf.WriteString(comma)
+	return nil
+}
@@ -0,0 +1,117 @@
+package json
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMerge3(t *testing.T) {
+	Convey("Merge3", t, func() {
+		base := []byte(`{"a": "1", "b": "2", "c": {"x": "3"}, "d": "4", "list": [1]}`)
+
+		Convey("takes the side that changed each value, keeping ours' layout", func() {
+			base := []byte(`{
+    "b": "2",
+    "a": "1",
+    "c": {"x": "3"},
+    "list": [1]
+}
+`)
+			ours := []byte(`{
+    "b": "ours",
+    "a": "1",
+    "c": {"x": "3", "y": "new"},
+    "list": [1]
+}
+`)
+			theirs := []byte(`{
+    "a": "theirs",
+    "b": "2",
+    "c": {"x": "3"},
+    "list": [1, 2],
+    "e": {
+        "f": "added"
+    }
+}`)
+			merged, conflicts, err := Merge3(base, ours, theirs, nil)
+			So(err, ShouldBeNil)
+			So(conflicts, ShouldBeEmpty)
+			So(string(merged), ShouldEqual, `{
+    "b": "ours",
+    "a": "theirs",
+    "c": {"x": "3", "y": "new"},
+    "list": [1, 2],
+    "e": {
+        "f": "added"
+    }
+}
+`)
+		})
+
+		Convey("marks values changed differently by both sides as conflicts", func() {
+			base := []byte("{\n  \"a\": \"1\",\n  \"b\": \"2\",\n  \"c\": {\n    \"x\": \"3\"\n  },\n  \"d\": \"4\"\n}\n")
+			ours := []byte("{\n  \"a\": \"ours\",\n  \"b\": \"2\",\n  \"c\": {\n    \"x\": \"ours\"\n  }\n}\n")
+			theirs := []byte(`{"a": "theirs", "b": "2", "c": {"x": "theirs"}, "d": "changed"}`)
+			merged, conflicts, err := Merge3(base, ours, theirs, nil)
+			So(err, ShouldBeNil)
+			So(conflicts, ShouldResemble, []string{"a", "c.x", "d"})
+			So(string(merged), ShouldEqual, `{
+<<<<<<< ours
+  "a": "ours",
+=======
+  "a": "theirs",
+>>>>>>> theirs
+  "b": "2",
+  "c": {
+<<<<<<< ours
+    "x": "ours"
+=======
+    "x": "theirs"
+>>>>>>> theirs
+  },
+<<<<<<< ours
+=======
+  "d": "changed"
+>>>>>>> theirs
+}
+`)
+		})
+
+		Convey("removes members removed by either side", func() {
+			ours := []byte(`{"a": "1", "c": {"x": "3"}, "d": "4", "list": [1]}`)
+			theirs := []byte(`{"a": "1", "b": "2", "c": {}, "d": "4"}`)
+			merged, conflicts, err := Merge3(base, ours, theirs, nil)
+			So(err, ShouldBeNil)
+			So(conflicts, ShouldBeEmpty)
+			So(string(merged), ShouldEqual, `{"a": "1", "c": {}, "d": "4"}`)
+		})
+
+		Convey("treats a key rotated on one side as a conflict with values encrypted on the other", func() {
+			boxed := `"EJ[1:12345678901234567890123456789012345678901234:12345678901234567890123456789012:a]"`
+			base := []byte(`{"_public_key": "old", "a": ` + boxed + `}`)
+			ours := []byte(`{"_public_key": "new", "a": "reencrypted"}`)
+			theirs := []byte(`{"_public_key": "old", "a": ` + boxed + `, "b": "plain"}`)
+			_, conflicts, err := Merge3(base, ours, theirs, nil)
+			So(err, ShouldBeNil)
+			So(conflicts, ShouldBeEmpty)
+
+			theirs = []byte(`{"_public_key": "old", "a": ` + boxed + `, "b": ` + boxed + `}`)
+			merged, conflicts, err := Merge3(base, ours, theirs, nil)
+			So(err, ShouldBeNil)
+			So(conflicts, ShouldResemble, []string{"_public_key"})
+			So(string(merged), ShouldStartWith, "{\n<<<<<<< ours\n\"_public_key\": \"new\",\n=======\n\"_public_key\": \"old\",\n>>>>>>> theirs\n")
+		})
+
+		Convey("compares values again with normalize", func() {
+			ours := []byte(`{"a": "ONE", "b": "2", "c": {"x": "3"}, "d": "4", "list": [1]}`)
+			theirs := []byte(`{"a": "one", "b": "2", "c": {"x": "3"}, "d": "4", "list": [1]}`)
+			_, conflicts, err := Merge3(base, ours, theirs, func(side int, value []byte) []byte {
+				return bytes.ToLower(value)
+			})
+			So(err, ShouldBeNil)
+			So(conflicts, ShouldBeEmpty)
+		})
+	})
+}
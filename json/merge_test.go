@@ -0,0 +1,50 @@
+package json
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMerge(t *testing.T) {
+	Convey("Merge", t, func() {
+		docs := [][]byte{
+			[]byte(`{"_public_key": "a", "db": {"host": "common", "port": 5432}, "hosts": ["a", "b"], "proxy": null, "debug": true}`),
+			[]byte(`{"_public_key": "b", "db": {"host": "prod", "pool": {"size": 5}}, "hosts": ["c"], "debug": null}`),
+			[]byte(`{"db": {"pool": {"size": 10}}, "region": "eu"}`),
+		}
+
+		Convey("deep-merges objects, replaces arrays and removes nulls", func() {
+			merged, origins, err := Merge(docs, PublicKeyField)
+			So(err, ShouldBeNil)
+			So(string(merged), ShouldEqual, `{
+  "db": {
+    "host": "prod",
+    "port": 5432,
+    "pool": {
+      "size": 10
+    }
+  },
+  "hosts": [
+    "c"
+  ],
+  "proxy": null,
+  "region": "eu"
+}
+`)
+			So(origins, ShouldResemble, []Origin{
+				{Path: "db.host", Layer: 1},
+				{Path: "db.port", Layer: 0},
+				{Path: "db.pool.size", Layer: 2},
+				{Path: "hosts", Layer: 1},
+				{Path: "proxy", Layer: 0},
+				{Path: "region", Layer: 2},
+			})
+		})
+
+		Convey("rejects documents that aren't objects", func() {
+			_, _, err := Merge([][]byte{docs[0], []byte(`[1]`)})
+			So(err, ShouldEqual, errNotObject)
+		})
+	})
+}
@@ -0,0 +1,83 @@
+package json
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var identifierPattern = regexp.MustCompile(`\A[A-Za-z_][A-Za-z0-9_-]*\z`)
+
+// pathFrame is one level of nesting in a document: either an object, with the
+// most recently read key, or an array, with the index of the current element.
+type pathFrame struct {
+	isArray bool
+	key     string
+	index   int
+}
+
+// path tracks the location of the Walker within a document as it is scanned.
+type path []pathFrame
+
+func (p *path) pushObject() {
+	*p = append(*p, pathFrame{})
+}
+
+func (p *path) pushArray() {
+	*p = append(*p, pathFrame{isArray: true})
+}
+
+func (p *path) pop() {
+	if len(*p) > 0 {
+		*p = (*p)[:len(*p)-1]
+	}
+}
+
+func (p path) setKey(key string) {
+	if len(p) > 0 {
+		p[len(p)-1].key = key
+	}
+}
+
+func (p path) nextIndex() {
+	if len(p) > 0 {
+		p[len(p)-1].index++
+	}
+}
+
+// hasUnderscoreKey reports whether any key along the path begins with an
+// underscore.
+func (p path) hasUnderscoreKey() bool {
+	for _, f := range p {
+		if !f.isArray && strings.HasPrefix(f.key, "_") {
+			return true
+		}
+	}
+	return false
+}
+
+// startsWith reports whether the path begins with the given top-level key.
+func (p path) startsWith(key string) bool {
+	return len(p) > 0 && !p[0].isArray && p[0].key == key
+}
+
+// String formats the path as a sequence of object keys and array indices,
+// e.g. `database.password` or `hosts[0].name`. Keys that aren't simple
+// identifiers are quoted: `tls["ca.pem"]`.
+func (p path) String() string {
+	var sb strings.Builder
+	for _, f := range p {
+		switch {
+		case f.isArray:
+			sb.WriteString("[" + strconv.Itoa(f.index) + "]")
+		case identifierPattern.MatchString(f.key):
+			if sb.Len() > 0 {
+				sb.WriteByte('.')
+			}
+			sb.WriteString(f.key)
+		default:
+			sb.WriteString("[" + strconv.Quote(f.key) + "]")
+		}
+	}
+	return sb.String()
+}
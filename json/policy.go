@@ -0,0 +1,159 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PolicyField is the key at which an EJSON document may store an encryption
+// policy, refining which values the underscore rule selects for encryption.
+// For example:
+//
+//	"_ejson": {
+//	  "include": ["public_urls"],
+//	  "exclude": ["public_urls.cdn", "*_id"],
+//	  "propagate_underscore": true,
+//	  "pad": true,
+//	  "compress": true
+//	}
+const PolicyField = "_ejson"
+
+// ErrPolicyInvalid means that the PolicyField key was found, but its value was
+// not a valid Policy.
+var ErrPolicyInvalid = errors.New("invalid _ejson policy")
+
+// Policy refines which values in a document are encrypted.
+//
+// Include and Exclude are lists of glob patterns over value paths (see
+// Value.Path). A value is encrypted only if it matches at least one Include
+// pattern (or Include is empty) and no Exclude pattern. In a pattern, "*"
+// matches any part of a single key, and "**" matches any number of keys and
+// array indices. A pattern matches a value if it matches the value's path or
+// the path of any of its ancestors, so "database" covers every value inside
+// the database object. A pattern that is a single key, like "*_id", matches
+// that key at any depth.
+//
+// Values under keys beginning with an underscore are never encrypted. If
+// PropagateUnderscore is set, that also applies to their children.
+//
+// If Pad is set, values are padded before they are encrypted, so that the
+// length of their ciphertext doesn't reveal their exact length. If Compress is
+// set, values are compressed before they are encrypted, where that makes them
+// smaller.
+type Policy struct {
+	Include             []string `json:"include"`
+	Exclude             []string `json:"exclude"`
+	PropagateUnderscore bool     `json:"propagate_underscore"`
+	Pad                 bool     `json:"pad"`
+	Compress            bool     `json:"compress"`
+
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// ExtractPolicy reads the PolicyField value from an EJSON document. It returns
+// nil if the document doesn't have one.
+func ExtractPolicy(data []byte) (*Policy, error) {
+	if err := validate(data); err != nil {
+		return nil, err
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		// Not an object, so there is nowhere for a policy to be.
+		return nil, nil
+	}
+	raw, ok := obj[PolicyField]
+	if !ok {
+		return nil, nil
+	}
+
+	var policy Policy
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPolicyInvalid, err)
+	}
+	if err := policy.compile(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// Selects reports whether the value at the given path matches the Include and
+// Exclude patterns of the policy. It doesn't take underscores into account.
+func (p *Policy) Selects(path string) bool {
+	if p == nil {
+		return true
+	}
+	if err := p.compile(); err != nil {
+		return false
+	}
+	return (len(p.include) == 0 || matchAny(p.include, path)) && !matchAny(p.exclude, path)
+}
+
+func (p *Policy) compile() (err error) {
+	if len(p.include) == len(p.Include) && len(p.exclude) == len(p.Exclude) {
+		return nil
+	}
+	if p.include, err = compilePatterns(p.Include); err != nil {
+		return err
+	}
+	p.exclude, err = compilePatterns(p.Exclude)
+	return err
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := compilePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// compilePattern turns a path glob into a regular expression matching the
+// paths it selects, including the paths of values nested inside them.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	switch {
+	case pattern == "":
+		return nil, fmt.Errorf("%w: empty pattern", ErrPolicyInvalid)
+	case strings.HasPrefix(pattern, "."), strings.HasSuffix(pattern, "."), strings.Contains(pattern, ".."):
+		return nil, fmt.Errorf("%w: empty key in pattern %q", ErrPolicyInvalid, pattern)
+	case strings.Contains(pattern, "***"):
+		return nil, fmt.Errorf("%w: invalid wildcard in pattern %q", ErrPolicyInvalid, pattern)
+	}
+
+	var expr strings.Builder
+	if !strings.ContainsAny(pattern, ".[") {
+		// A single key matches at any depth.
+		expr.WriteString(`(?:.*\.)?`)
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(`.*`)
+			i++
+		case pattern[i] == '*':
+			expr.WriteString(`[^.\[\]]*`)
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	return regexp.Compile(`\A` + expr.String() + `(?:[.\[].*)?\z`)
+}
+
+func matchAny(res []*regexp.Regexp, path string) bool {
+	for _, re := range res {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package json
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPolicy(t *testing.T) {
+	Convey("ExtractPolicy", t, func() {
+		Convey("returns nil when the field is absent", func() {
+			policy, err := ExtractPolicy([]byte(`{"a": "b"}`))
+			So(err, ShouldBeNil)
+			So(policy, ShouldBeNil)
+		})
+
+		Convey("rejects unknown fields", func() {
+			_, err := ExtractPolicy([]byte(`{"_ejson": {"exclud": ["a"]}}`))
+			So(errors.Is(err, ErrPolicyInvalid), ShouldBeTrue)
+		})
+
+		Convey("rejects invalid patterns", func() {
+			_, err := ExtractPolicy([]byte(`{"_ejson": {"exclude": ["a..b"]}}`))
+			So(errors.Is(err, ErrPolicyInvalid), ShouldBeTrue)
+		})
+	})
+
+	Convey("Policy.Selects", t, func() {
+		policy := &Policy{
+			Include: []string{"public_urls", "db.*"},
+			Exclude: []string{"public_urls.cdn", "*_id", "**.debug"},
+		}
+		So(policy.Selects("public_urls.api"), ShouldBeTrue)
+		So(policy.Selects("public_urls.cdn"), ShouldBeFalse)
+		So(policy.Selects("public_urls.cdn.host"), ShouldBeFalse)
+		So(policy.Selects("db.password"), ShouldBeTrue)
+		So(policy.Selects("db.hosts[0]"), ShouldBeTrue)
+		So(policy.Selects("db.account_id"), ShouldBeFalse)
+		So(policy.Selects("db.x.debug"), ShouldBeFalse)
+		So(policy.Selects("other"), ShouldBeFalse)
+		So((*Policy)(nil).Selects("other"), ShouldBeTrue)
+	})
+}
+
+// "E" means encrypted.
+var policyWalkTestCases = []testCase{
+	{
+		`{"_ejson": {"exclude": ["*_id"]}, "a": "b", "user_id": "c", "d": {"e_id": "f"}}`,
+		`{"_ejson": {"exclude": ["*_id"]}, "a": "E", "user_id": "c", "d": {"e_id": "f"}}`,
+	},
+	{
+		`{"_ejson": {"include": ["k"], "exclude": ["k.x"]}, "a": "b", "k": {"x": "y", "z": "w"}}`,
+		`{"_ejson": {"include": ["k"], "exclude": ["k.x"]}, "a": "b", "k": {"x": "y", "z": "E"}}`,
+	},
+	{
+		`{"_ejson": {"propagate_underscore": true}, "_a": {"b": ["c"]}, "d": {"e": "f"}}`,
+		`{"_ejson": {"propagate_underscore": true}, "_a": {"b": ["c"]}, "d": {"e": "E"}}`,
+	},
+}
+
+func TestWalkerPolicy(t *testing.T) {
+	action := func(a []byte) ([]byte, error) {
+		return []byte{'E'}, nil
+	}
+
+	Convey("Walker honours the document's policy", t, func() {
+		for _, tc := range policyWalkTestCases {
+			walker := Walker{Action: action}
+			act, err := walker.Walk([]byte(tc.in))
+			So(err, ShouldBeNil)
+			So(string(act), ShouldEqual, tc.out)
+		}
+	})
+}
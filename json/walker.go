@@ -31,8 +31,28 @@ import (
 //   - In {"k": {"a": ["b"]}, Action will run on "b".
 //   - In {"_k": {"a": ["b"]}, Action run on "b".
 //   - In {"k": {"_a": ["b"]}, Action will not run.
+//
+// A document may refine this selection with a Policy stored under its
+// PolicyField key, which Walk reads before doing anything else. See Policy for
+// details.
+//
+// If ValueAction is set, it is used instead of Action. It is run on the same
+// strings, but also on numbers, booleans and nulls selected by the same
+// rules, and may replace a value with one of a different type.
 type Walker struct {
-	Action func([]byte) ([]byte, error)
+	Action      func([]byte) ([]byte, error)
+	ValueAction func(Value) (Value, error)
+}
+
+// Value is a scalar selected by a Walker.
+type Value struct {
+	// Path locates the value in the document, e.g. `database.password` or
+	// `hosts[0]`.
+	Path string
+	// Literal is true if Data is the text of a JSON number, boolean or null
+	// literal, and false if it is the (unquoted) contents of a string.
+	Literal bool
+	Data    []byte
 }
 
 // It's common to want to paste multiline secrets into an EJSON file, and JSON
@@ -47,7 +67,7 @@ func CollapseMultilineStringLiterals(data []byte) ([]byte, error) {
 	)
 
 	scanner.Reset()
-	for _, c := range data {
+	for i, c := range data {
 		if inString && c == '\n' {
 			buf = append(buf, []byte{'\\', 'n'}...)
 			continue
@@ -74,7 +94,7 @@ func CollapseMultilineStringLiterals(data []byte) ([]byte, error) {
 			esc = false
 			inString = (c == '"')
 		case json.ScanError:
-			return nil, fmt.Errorf("invalid json")
+			return nil, newSyntaxError(data, i, scanner.Err, inString)
 		case json.ScanEnd:
 			return buf, nil
 		default:
@@ -84,22 +104,28 @@ func CollapseMultilineStringLiterals(data []byte) ([]byte, error) {
 	}
 	if scanner.EOF() == json.ScanError {
 		// Unexpected EOF => malformed JSON
-		return nil, fmt.Errorf("invalid json")
+		return nil, newSyntaxError(data, len(data), scanner.Err, inString)
 	}
 	return buf, nil
 }
 
 // Walk walks an entire JSON structure, running the ejsonWalker.Action on each
-// actionable node. A node is actionable if it's a string *value*, and its
-// referencing key doesn't begin with an underscore. For each actionable node,
-// the contents are replaced with the result of Action. Everything else is
-// unchanged.
+// actionable node. A node is actionable if it's a string *value*, its
+// referencing key doesn't begin with an underscore, and it is selected by the
+// document's Policy, if any. For each actionable node, the contents are
+// replaced with the result of Action. Everything else is unchanged.
 func (ew *Walker) Walk(data []byte) ([]byte, error) {
+	policy, err := ExtractPolicy(data)
+	if err != nil {
+		return nil, err
+	}
+
 	var (
 		inLiteral    bool
 		literalStart int
 		isComment    bool
 		scanner      json.Scanner
+		location     path
 	)
 	scanner.Reset()
 	pline := newPipeline()
@@ -116,11 +142,12 @@ func (ew *Walker) Walk(data []byte) ([]byte, error) {
 			// underscore, then append it verbatim to the output buffer.
 			inLiteral = false
 			isComment = data[literalStart+1] == '_'
+			location.setKey(unquoteKey(data[literalStart:i]))
 			pline.appendBytes(data[literalStart:i])
 		case json.ScanError:
 			// Some error happened; just bail.
 			pline.flush()
-			return nil, fmt.Errorf("invalid json")
+			return nil, newSyntaxError(data, i, scanner.Err, inLiteral && data[literalStart] == '"')
 		case json.ScanEnd:
 			// We successfully hit the end of input.
 			pline.appendByte(c)
@@ -132,18 +159,38 @@ func (ew *Walker) Walk(data []byte) ([]byte, error) {
 				// potentially encryptable. If it was a string, and the most recent Key
 				// encountered didn't begin with a '_', we are to encrypt it. In any
 				// other case, we append it verbatim to the output buffer.
-				if isComment || data[literalStart] != '"' {
+				isString := data[literalStart] == '"'
+				// The policy and signature are never encrypted, so that they can always
+				// be read.
+				exempt := isComment || location.startsWith(PolicyField) || location.startsWith(SignatureField) ||
+					(policy != nil && policy.PropagateUnderscore && location.hasUnderscoreKey())
+				if exempt || (!isString && ew.ValueAction == nil) || !policy.Selects(location.String()) {
 					pline.appendBytes(data[literalStart:i])
 				} else {
 					res := make(chan promiseResult)
-					go func(subData []byte) {
-						actioned, err := ew.runAction(subData)
+					go func(subData []byte, valuePath string) {
+						actioned, err := ew.runAction(subData, valuePath)
+						if err != nil {
+							err = &ValueError{Path: valuePath, Err: err}
+						}
 						res <- promiseResult{actioned, err}
 						close(res)
-					}(data[literalStart:i])
+					}(data[literalStart:i], location.String())
 					pline.appendPromise(res)
 				}
 			}
+			// Keep track of where we are in the document, so that errors can be
+			// reported against the path of the value that caused them.
+			switch v {
+			case json.ScanBeginObject:
+				location.pushObject()
+			case json.ScanBeginArray:
+				location.pushArray()
+			case json.ScanArrayValue:
+				location.nextIndex()
+			case json.ScanEndObject, json.ScanEndArray:
+				location.pop()
+			}
 		}
 		if !inLiteral {
 			// If we're in a literal, we save up bytes because we may have to encrypt
@@ -154,26 +201,50 @@ func (ew *Walker) Walk(data []byte) ([]byte, error) {
 	if scanner.EOF() == json.ScanError {
 		// Unexpected EOF => malformed JSON
 		pline.flush()
-		return nil, fmt.Errorf("invalid json")
+		return nil, newSyntaxError(data, len(data), scanner.Err, inLiteral && data[literalStart] == '"')
 	}
 	return pline.flush()
 }
 
-func (ew *Walker) runAction(data []byte) ([]byte, error) {
+func (ew *Walker) runAction(data []byte, valuePath string) ([]byte, error) {
 	trimmed := bytes.TrimSpace(data)
-	unquoted, ok := json.UnquoteBytes(trimmed)
-	if !ok {
-		return nil, fmt.Errorf("invalid json")
+	v := Value{Path: valuePath, Literal: trimmed[0] != '"', Data: trimmed}
+	if !v.Literal {
+		unquoted, ok := json.UnquoteBytes(trimmed)
+		if !ok {
+			return nil, fmt.Errorf("invalid json")
+		}
+		v.Data = unquoted
+	}
+
+	var err error
+	if ew.ValueAction != nil {
+		v, err = ew.ValueAction(v)
+	} else {
+		v.Data, err = ew.Action(v.Data)
 	}
-	done, err := ew.Action(unquoted)
 	if err != nil {
 		return nil, err
 	}
-	quoted, err := quoteBytes(done)
-	if err != nil {
+
+	var done []byte
+	if v.Literal {
+		// v.Data may still point into the document, so copy it before appending.
+		done = append(done, v.Data...)
+	} else if done, err = quoteBytes(v.Data); err != nil {
 		return nil, err
 	}
-	return append(quoted, data[len(trimmed):]...), nil
+	return append(done, data[len(trimmed):]...), nil
+}
+
+// unquoteKey returns the decoded form of an object key literal, as it appears
+// in the document (possibly followed by whitespace).
+func unquoteKey(data []byte) string {
+	unquoted, ok := json.UnquoteBytes(bytes.TrimSpace(data))
+	if !ok {
+		return string(bytes.TrimSpace(data))
+	}
+	return string(unquoted)
 }
 
 // quoteBytes takes a byte slice and returns it as a properly quoted JSON string.
@@ -1,6 +1,7 @@
 package json
 
 import (
+	"errors"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -48,6 +49,8 @@ var walkTestCases = []testCase{
 	{`{"a": {"b": "c"}}`, `{"a": {"b": "E"}}`},       // nesting
 	{`{"a": {"_b": "c"}}`, `{"a": {"_b": "c"}}`},     // nested comment
 	{`{"_a": {"b": "c"}}`, `{"_a": {"b": "E"}}`},     // comments don't inherit
+
+	{`{"_signature": {"sig": "s"}}`, `{"_signature": {"sig": "s"}}`}, // signatures are never encrypted
 }
 
 func TestQuoteBytes(t *testing.T) {
@@ -78,3 +81,59 @@ var collapseTestCases = []testCase{
 		"{\"a\": \"b\\r\\nc\\nd\"\r\n}",
 	},
 }
+
+func TestSyntaxErrors(t *testing.T) {
+	Convey("Malformed documents", t, func() {
+		Convey("report the line and column of the offending character", func() {
+			in := "{\n  \"a\": \"b\",\n  \"c\" \"d\"\n}"
+			_, err := CollapseMultilineStringLiterals([]byte(in))
+			So(err, ShouldHaveSameTypeAs, &SyntaxError{})
+			se := err.(*SyntaxError)
+			So(se.Line, ShouldEqual, 3)
+			So(se.Column, ShouldEqual, 7)
+			So(se.Offset, ShouldEqual, 20)
+			So(se.Char, ShouldEqual, '"')
+			So(se.Error(), ShouldContainSubstring, "invalid json")
+			So(se.Context, ShouldEqual, `  "..." "..."`)
+		})
+
+		Convey("report unexpected EOF from the walker", func() {
+			walker := Walker{Action: func(a []byte) ([]byte, error) { return a, nil }}
+			_, err := walker.Walk([]byte(`{"a": "b"`))
+			So(err, ShouldHaveSameTypeAs, &SyntaxError{})
+			se := err.(*SyntaxError)
+			So(se.Line, ShouldEqual, 1)
+			So(se.Column, ShouldEqual, 10)
+			So(se.Msg, ShouldEqual, "unexpected end of JSON input")
+		})
+
+		Convey("never include the contents of string values", func() {
+			in := `{"password": "hunter2", "pin": "12\q4"}`
+			_, err := CollapseMultilineStringLiterals([]byte(in))
+			So(err, ShouldHaveSameTypeAs, &SyntaxError{})
+			se := err.(*SyntaxError)
+			So(se.Char, ShouldEqual, 0)
+			So(se.Error(), ShouldNotContainSubstring, "q")
+			So(se.Context, ShouldNotContainSubstring, "hunter2")
+			So(se.Context, ShouldNotContainSubstring, "12")
+			So(se.Context, ShouldContainSubstring, `"pin"`)
+		})
+	})
+}
+
+func TestValueErrors(t *testing.T) {
+	Convey("Action failures are wrapped with the path of the value", t, func() {
+		fail := errors.New("nope")
+		walker := Walker{Action: func(a []byte) ([]byte, error) {
+			if string(a) == "bad" {
+				return nil, fail
+			}
+			return a, nil
+		}}
+		_, err := walker.Walk([]byte(`{"a": "ok", "b": {"c": ["ok", {"d.e": "bad"}]}}`))
+		So(errors.Is(err, fail), ShouldBeTrue)
+		var valueErr *ValueError
+		So(errors.As(err, &valueErr), ShouldBeTrue)
+		So(valueErr.Path, ShouldEqual, `b.c[1]["d.e"]`)
+	})
+}
@@ -0,0 +1,65 @@
+package ejson
+
+import (
+	"fmt"
+
+	"github.com/Shopify/ejson/crypto"
+)
+
+// KeyProvider looks up private keys for decryption. PrivateKey returns the
+// private key matching a public key, in any form a keydir file may take (see
+// Keydir), or an error matching ErrKeyNotFound if it doesn't have it.
+type KeyProvider interface {
+	PrivateKey(pub [32]byte) (string, error)
+}
+
+// KeyProviderFunc adapts a function to a KeyProvider.
+type KeyProviderFunc func(pub [32]byte) (string, error)
+
+// PrivateKey calls f(pub).
+func (f KeyProviderFunc) PrivateKey(pub [32]byte) (string, error) {
+	return f(pub)
+}
+
+// Keydir is a KeyProvider that reads private keys from a keydir, in which
+// each is stored in a file named for its hex-encoded public key, as either
+// the hex-encoded private key or an age identity.
+type Keydir string
+
+// PrivateKey reads the private key for pub from the keydir.
+func (k Keydir) PrivateKey(pub [32]byte) (string, error) {
+	return readPrivateKeyFromDisk(pub, string(k))
+}
+
+// keyProvider returns the KeyProvider for the keydir and user-supplied
+// private key taken by functions like Decrypt: the user-supplied key, if
+// there is one, or else the keydir.
+func keyProvider(keydir string, userSuppliedPrivateKey string) KeyProvider {
+	if userSuppliedPrivateKey != "" {
+		return KeyProviderFunc(func([32]byte) (string, error) {
+			return userSuppliedPrivateKey, nil
+		})
+	}
+	return Keydir(keydir)
+}
+
+// lookupPrivateKey gets the private key for pubkey from keys, checking that
+// it really is the matching key.
+func lookupPrivateKey(pubkey [32]byte, keys KeyProvider) (privkey [32]byte, err error) {
+	privkeyString, err := keys.PrivateKey(pubkey)
+	if err != nil {
+		return privkey, err
+	}
+	if privkey, err = parsePrivateKey(privkeyString); err != nil {
+		return
+	}
+
+	// Catch mislabelled key files and mistyped keys now, rather than failing to
+	// decrypt each value later.
+	var kp crypto.Keypair
+	kp.FromPrivate(privkey)
+	if kp.Public != pubkey {
+		err = fmt.Errorf("%w %x", ErrKeyMismatch, pubkey)
+	}
+	return
+}
@@ -0,0 +1,170 @@
+package ejson
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/Shopify/ejson/crypto"
+	"github.com/Shopify/ejson/json"
+)
+
+// ErrKeyMismatch means that a private key doesn't correspond to the public
+// key it was expected to match.
+var ErrKeyMismatch = errors.New("private key does not match public key")
+
+// ErrNotKeyFile means that a file in the keydir isn't named for a public key.
+var ErrNotKeyFile = errors.New("file name is not a public key")
+
+// ErrKeyPermissions means that a key file can be read or written by users
+// other than its owner (and, for reading, its group).
+var ErrKeyPermissions = errors.New("key file is accessible by other users")
+
+// KeyFile describes a file in a keydir.
+type KeyFile struct {
+	Path string
+	// PublicKey is the public key the file is named for.
+	PublicKey string
+	// Err is the reason the file isn't a usable key, or nil if it is.
+	Err error
+}
+
+// ListKeys returns every file in keydir, sorted by name, validating each as a
+// key file: its name must be the public key derived from the private key it
+// contains, and it must not be accessible by other users.
+func ListKeys(keydir string) ([]KeyFile, error) {
+	entries, err := os.ReadDir(keydir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []KeyFile
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(keydir, entry.Name())
+		keys = append(keys, KeyFile{
+			Path:      path,
+			PublicKey: entry.Name(),
+			Err:       CheckKeyFile(path),
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].PublicKey < keys[j].PublicKey })
+	return keys, nil
+}
+
+// CheckKeyFile validates the key file at path, as described for ListKeys.
+func CheckKeyFile(path string) error {
+	name := filepath.Base(path)
+	if pub, err := hex.DecodeString(name); err != nil || len(pub) != 32 {
+		return ErrNotKeyFile
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	// Windows doesn't have meaningful permission bits.
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o027 != 0 {
+		return fmt.Errorf("%w (mode %04o)", ErrKeyPermissions, info.Mode().Perm())
+	}
+
+	derived, err := keyFilePublicKey(path)
+	if err != nil {
+		return err
+	}
+	if derived != name {
+		return fmt.Errorf("%w: private key belongs to %s", ErrKeyMismatch, derived)
+	}
+	return nil
+}
+
+// keyFilePublicKey returns the hex-encoded public key derived from the
+// private key in the key file at path.
+func keyFilePublicKey(path string) (string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", &KeyFileError{Path: path, Err: err}
+	}
+	privkey, err := parsePrivateKey(string(contents))
+	if err != nil {
+		return "", err
+	}
+	var kp crypto.Keypair
+	kp.FromPrivate(privkey)
+	return kp.PublicString(), nil
+}
+
+// WhichKey returns the path of the key file in keydir which decrypts the
+// EJSON document read from 'in', after checking that the private key in it
+// matches the document's _public_key.
+func WhichKey(in io.Reader, keydir string) (string, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return "", err
+	}
+	pubkey, err := json.ExtractPublicKey(data)
+	if err != nil {
+		return "", err
+	}
+	if _, err := lookupPrivateKey(pubkey, Keydir(keydir)); err != nil {
+		return "", err
+	}
+	return filepath.Join(keydir, fmt.Sprintf("%x", pubkey)), nil
+}
+
+// UnusedKeys returns the valid key files in keydir whose public keys, as
+// derived from the private keys they contain, aren't used by any of the given
+// files. A key is used by an EJSON file whose _public_key it is, and, so as to
+// err on the side of keeping keys, by any other file that mentions it in hex.
+// Files that aren't valid key files are never returned: they may hold keys
+// that are in use, under the wrong name.
+func UnusedKeys(keydir string, files []string) ([]KeyFile, error) {
+	keys, err := ListKeys(keydir)
+	if err != nil {
+		return nil, err
+	}
+	derived := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if key.Err != nil {
+			continue
+		}
+		pub, err := keyFilePublicKey(key.Path)
+		if err != nil {
+			return nil, err
+		}
+		derived[key.Path] = pub
+	}
+
+	used := make(map[string]bool)
+	for _, filePath := range files {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if pubkey, err := json.ExtractPublicKey(data); err == nil {
+			used[fmt.Sprintf("%x", pubkey)] = true
+			continue
+		}
+		lower := bytes.ToLower(data)
+		for _, pub := range derived {
+			if bytes.Contains(lower, []byte(pub)) {
+				used[pub] = true
+			}
+		}
+	}
+
+	var unused []KeyFile
+	for _, key := range keys {
+		if pub, ok := derived[key.Path]; ok && !used[pub] {
+			unused = append(unused, key)
+		}
+	}
+	return unused, nil
+}
@@ -0,0 +1,91 @@
+package ejson
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/ejson/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKeyInventory(t *testing.T) {
+	Convey("Key inventory", t, func() {
+		keydir := t.TempDir()
+		So(os.WriteFile(filepath.Join(keydir, validPubKey), []byte(validPrivKey+"\n"), 0o440), ShouldBeNil)
+
+		Convey("ListKeys", func() {
+			So(os.WriteFile(filepath.Join(keydir, invalidPubKey), []byte(validPrivKey), 0o400), ShouldBeNil)
+			So(os.WriteFile(filepath.Join(keydir, "README"), []byte("hello"), 0o400), ShouldBeNil)
+
+			keys, err := ListKeys(keydir)
+			So(err, ShouldBeNil)
+			So(len(keys), ShouldEqual, 3)
+			So(keys[0].PublicKey, ShouldEqual, invalidPubKey)
+			So(errors.Is(keys[0].Err, ErrKeyMismatch), ShouldBeTrue)
+			So(keys[1].PublicKey, ShouldEqual, validPubKey)
+			So(keys[1].Err, ShouldBeNil)
+			So(keys[2].Err, ShouldEqual, ErrNotKeyFile)
+		})
+
+		Convey("CheckKeyFile rejects loose permissions", func() {
+			path := filepath.Join(keydir, validPubKey)
+			So(os.Chmod(path, 0o644), ShouldBeNil)
+			So(errors.Is(CheckKeyFile(path), ErrKeyPermissions), ShouldBeTrue)
+		})
+
+		Convey("WhichKey finds the key that decrypts a document", func() {
+			doc := `{"_public_key": "` + validPubKey + `", "a": "EJ[1:KR1IxNZnTZQMP3OR1NdOpDQ1IcLD83FSuE7iVNzINDk=:XnYW1HOxMthBFMnxWULHlnY4scj5mNmX:ls1+kvwwu2ETz5C6apgWE7Q=]"}`
+			path, err := WhichKey(strings.NewReader(doc), keydir)
+			So(err, ShouldBeNil)
+			So(path, ShouldEqual, filepath.Join(keydir, validPubKey))
+
+			_, err = WhichKey(strings.NewReader(`{"_public_key": "`+invalidPubKey+`"}`), keydir)
+			So(errors.Is(err, ErrKeyNotFound), ShouldBeTrue)
+		})
+
+		Convey("UnusedKeys lists keys not referenced by any document", func() {
+			var spare crypto.Keypair
+			So(spare.Generate(), ShouldBeNil)
+			sparePath := filepath.Join(keydir, spare.PublicString())
+			So(os.WriteFile(sparePath, []byte(spare.PrivateString()), 0o400), ShouldBeNil)
+			So(os.WriteFile(filepath.Join(keydir, invalidPubKey), []byte(validPrivKey), 0o400), ShouldBeNil)
+			So(os.WriteFile(filepath.Join(keydir, "README"), []byte("hello"), 0o400), ShouldBeNil)
+			doc := filepath.Join(t.TempDir(), "a.ejson")
+			So(os.WriteFile(doc, []byte(`{"_public_key": "`+validPubKey+`"}`), 0o644), ShouldBeNil)
+
+			unused, err := UnusedKeys(keydir, []string{doc})
+			So(err, ShouldBeNil)
+			So(len(unused), ShouldEqual, 1)
+			So(unused[0].Path, ShouldEqual, sparePath)
+
+			Convey("counting any file that mentions a key as using it", func() {
+				other := filepath.Join(t.TempDir(), "deploy.yml")
+				So(os.WriteFile(other, []byte("key: "+strings.ToUpper(spare.PublicString())+"\n"), 0o644), ShouldBeNil)
+				unused, err := UnusedKeys(keydir, []string{doc, other})
+				So(err, ShouldBeNil)
+				So(unused, ShouldBeEmpty)
+			})
+
+			Convey("failing if a file can't be read", func() {
+				_, err := UnusedKeys(keydir, []string{doc, filepath.Join(t.TempDir(), "missing.ejson")})
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("WhichKey doesn't need the values to be decryptable", func() {
+			doc := `{"_public_key": "` + validPubKey + `", "a": "plain", "b": "EJ[1:garbage]"}`
+			path, err := WhichKey(strings.NewReader(doc), keydir)
+			So(err, ShouldBeNil)
+			So(path, ShouldEqual, filepath.Join(keydir, validPubKey))
+		})
+
+		Convey("WhichKey rejects a mislabelled key file", func() {
+			So(os.WriteFile(filepath.Join(keydir, invalidPubKey), []byte(validPrivKey), 0o400), ShouldBeNil)
+			_, err := WhichKey(strings.NewReader(`{"_public_key": "`+invalidPubKey+`"}`), keydir)
+			So(errors.Is(err, ErrKeyMismatch), ShouldBeTrue)
+		})
+	})
+}
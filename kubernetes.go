@@ -0,0 +1,257 @@
+package ejson
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Shopify/ejson/crypto"
+	ejsonjson "github.com/Shopify/ejson/json"
+)
+
+// Metadata keys read by KubernetesSecret from the object it converts.
+const (
+	secretLabelsField      = "_labels"
+	secretAnnotationsField = "_annotations"
+	secretTypeField        = "_type"
+)
+
+// SealedPublicKeyAnnotation is the annotation in which KubernetesSecret
+// records the public key of a sealed Secret's values.
+const SealedPublicKeyAnnotation = "ejson/public-key"
+
+// ErrInvalidSecret means that a document couldn't be converted to a
+// Kubernetes Secret.
+var ErrInvalidSecret = errors.New("can't make a Kubernetes Secret")
+
+var secretKeyPattern = regexp.MustCompile(`\A[-._a-zA-Z0-9]+\z`)
+
+// SecretOptions describes the Kubernetes Secret made by KubernetesSecret.
+type SecretOptions struct {
+	Name      string
+	Namespace string
+	// Path selects the object to convert, as a series of keys separated by
+	// dots, like "kubernetes.web". If empty, the whole document is used.
+	Path string
+	// StringData puts the values in the Secret's stringData, rather than
+	// base64-encoding them into its data.
+	StringData bool
+	// Sealed means that the document hasn't been decrypted, so its values
+	// are still encrypted. They are put in stringData as they are, for a
+	// decrypter running in the cluster, and the document's public key is
+	// recorded in the SealedPublicKeyAnnotation. A document with values that
+	// should be encrypted but aren't is refused, as Check would, and so are
+	// encrypted labels and annotations.
+	Sealed bool
+}
+
+// KubernetesSecret converts an object in a decrypted EJSON document (or an
+// encrypted one, if opts.Sealed is set) to the YAML manifest of a Kubernetes
+// Secret. Each member of the object whose key doesn't begin with an
+// underscore becomes an entry in the Secret, and must be a string, number or
+// boolean. The object may give the Secret's labels and annotations as objects
+// under _labels and _annotations, and its type under _type (Opaque by
+// default). If the object's only other member is an object named data, as in
+// the kubernetes_secrets written by "ejson init --template kubernetes", the
+// entries are taken from that instead.
+func KubernetesSecret(doc []byte, opts SecretOptions) ([]byte, error) {
+	if opts.Name == "" {
+		return nil, fmt.Errorf("%w: a name must be given", ErrInvalidSecret)
+	}
+	doc, err := ejsonjson.CollapseMultilineStringLiterals(doc)
+	if err != nil {
+		return nil, err
+	}
+	var root interface{}
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	if err := dec.Decode(&root); err != nil {
+		return nil, err
+	}
+	selected, err := selectPath(root, opts.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSecret, err)
+	}
+	obj, ok := selected.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not an object", ErrInvalidSecret, describePath(opts.Path))
+	}
+
+	labels, err := stringMap(obj, secretLabelsField)
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := stringMap(obj, secretAnnotationsField)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Sealed {
+		if err := Check(bytes.NewReader(doc)); err != nil {
+			return nil, err
+		}
+		pub, err := ejsonjson.ExtractPublicKey(doc)
+		if err != nil {
+			return nil, err
+		}
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		for field, metadata := range map[string]map[string]string{secretLabelsField: labels, secretAnnotationsField: annotations} {
+			for key, value := range metadata {
+				if crypto.IsBoxedMessage([]byte(value)) {
+					return nil, fmt.Errorf("%w: %s.%s is encrypted, so can't be used in a sealed Secret", ErrInvalidSecret, field, key)
+				}
+			}
+		}
+		annotations[SealedPublicKeyAnnotation] = fmt.Sprintf("%x", pub)
+	}
+	secretType := "Opaque"
+	if t, ok := obj[secretTypeField]; ok {
+		if secretType, ok = t.(string); !ok {
+			return nil, fmt.Errorf("%w: %s must be a string", ErrInvalidSecret, secretTypeField)
+		}
+	}
+
+	entries := obj
+	if inner, ok := kraneData(obj); ok {
+		entries = inner
+	}
+	data := make(map[string]string)
+	for key, value := range entries {
+		if strings.HasPrefix(key, "_") {
+			continue
+		}
+		if !secretKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("%w: %q is not a valid Secret key", ErrInvalidSecret, key)
+		}
+		switch value := value.(type) {
+		case string:
+			data[key] = value
+		case json.Number:
+			data[key] = value.String()
+		case bool:
+			data[key] = fmt.Sprint(value)
+		default:
+			return nil, fmt.Errorf("%w: the value of %q must be a string, number or boolean", ErrInvalidSecret, key)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("apiVersion: v1\nkind: Secret\nmetadata:\n")
+	fmt.Fprintf(&buf, "  name: %s\n", yamlString(opts.Name))
+	if opts.Namespace != "" {
+		fmt.Fprintf(&buf, "  namespace: %s\n", yamlString(opts.Namespace))
+	}
+	writeYAMLMap(&buf, "  ", "labels", labels)
+	writeYAMLMap(&buf, "  ", "annotations", annotations)
+	fmt.Fprintf(&buf, "type: %s\n", yamlString(secretType))
+	if opts.StringData || opts.Sealed {
+		writeYAMLMap(&buf, "", "stringData", data)
+	} else {
+		for key, value := range data {
+			data[key] = base64.StdEncoding.EncodeToString([]byte(value))
+		}
+		writeYAMLMap(&buf, "", "data", data)
+	}
+	return buf.Bytes(), nil
+}
+
+// kraneData returns the data object of a secret in the layout written by
+// "ejson init --template kubernetes" for krane, in which the entries are in a
+// data object next to _type, and whether obj is laid out that way.
+func kraneData(obj map[string]interface{}) (map[string]interface{}, bool) {
+	for key := range obj {
+		if key != "data" && !strings.HasPrefix(key, "_") {
+			return nil, false
+		}
+	}
+	data, ok := obj["data"].(map[string]interface{})
+	return data, ok
+}
+
+// selectPath returns the value at a path of keys separated by dots within a
+// decoded document, or the document itself if path is empty.
+func selectPath(root interface{}, path string) (interface{}, error) {
+	if path == "" {
+		return root, nil
+	}
+	value := root
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s is not in the document", path)
+		}
+		if value, ok = obj[key]; !ok {
+			return nil, fmt.Errorf("%s is not in the document", path)
+		}
+	}
+	return value, nil
+}
+
+func describePath(path string) string {
+	if path == "" {
+		return "the document"
+	}
+	return path
+}
+
+// stringMap reads an object of strings from the member of obj with the given
+// key, returning nil if there is no such member.
+func stringMap(obj map[string]interface{}, key string) (map[string]string, error) {
+	raw, ok := obj[key]
+	if !ok {
+		return nil, nil
+	}
+	members, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s must be an object", ErrInvalidSecret, key)
+	}
+	res := make(map[string]string, len(members))
+	for k, v := range members {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s.%s must be a string", ErrInvalidSecret, key, k)
+		}
+		res[k] = s
+	}
+	return res, nil
+}
+
+// writeYAMLMap writes a YAML mapping of strings under the given key, with
+// its entries sorted. It writes nothing if the mapping is nil.
+func writeYAMLMap(buf *bytes.Buffer, indent, key string, m map[string]string) {
+	if m == nil {
+		return
+	}
+	if len(m) == 0 {
+		fmt.Fprintf(buf, "%s%s: {}\n", indent, key)
+		return
+	}
+	fmt.Fprintf(buf, "%s%s:\n", indent, key)
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(buf, "%s  %s: %s\n", indent, yamlString(k), yamlString(m[k]))
+	}
+}
+
+// yamlString quotes a string for YAML. A JSON string is also a YAML
+// double-quoted scalar.
+func yamlString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		// Strings always encode.
+		panic(err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
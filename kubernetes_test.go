@@ -0,0 +1,103 @@
+package ejson
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKubernetesSecret(t *testing.T) {
+	Convey("KubernetesSecret", t, func() {
+		decrypted := []byte(`{
+			"_public_key": "` + validPubKey + `",
+			"web": {
+				"_labels": {"app": "web"},
+				"_annotations": {"team": "payments"},
+				"_type": "Opaque",
+				"password": "hunter2",
+				"port": 5432,
+				"debug": false
+			},
+			"nested": {"deeper": {"a": "b"}}
+		}`)
+
+		Convey("should base64-encode the selected object into data", func() {
+			out, err := KubernetesSecret(decrypted, SecretOptions{Name: "web", Namespace: "prod", Path: "web"})
+			So(err, ShouldBeNil)
+			So(string(out), ShouldEqual, `apiVersion: v1
+kind: Secret
+metadata:
+  name: "web"
+  namespace: "prod"
+  labels:
+    "app": "web"
+  annotations:
+    "team": "payments"
+type: "Opaque"
+data:
+  "debug": "ZmFsc2U="
+  "password": "aHVudGVyMg=="
+  "port": "NTQzMg=="
+`)
+		})
+
+		Convey("should put values in stringData when asked", func() {
+			out, err := KubernetesSecret(decrypted, SecretOptions{Name: "web", Path: "web", StringData: true})
+			So(err, ShouldBeNil)
+			So(string(out), ShouldContainSubstring, "stringData:\n  \"debug\": \"false\"\n  \"password\": \"hunter2\"\n")
+			So(string(out), ShouldNotContainSubstring, "namespace")
+		})
+
+		Convey("should read krane's layout", func() {
+			krane := []byte(`{"_public_key": "` + validPubKey + `", "kubernetes_secrets": {"web": {"_type": "kubernetes.io/tls", "data": {"tls.key": "k"}}}}`)
+			out, err := KubernetesSecret(krane, SecretOptions{Name: "web", Path: "kubernetes_secrets.web", StringData: true})
+			So(err, ShouldBeNil)
+			So(string(out), ShouldEndWith, "type: \"kubernetes.io/tls\"\nstringData:\n  \"tls.key\": \"k\"\n")
+		})
+
+		Convey("should refuse values that aren't scalars", func() {
+			_, err := KubernetesSecret(decrypted, SecretOptions{Name: "all"})
+			So(errors.Is(err, ErrInvalidSecret), ShouldBeTrue)
+			_, err = KubernetesSecret(decrypted, SecretOptions{Name: "x", Path: "nested.missing"})
+			So(errors.Is(err, ErrInvalidSecret), ShouldBeTrue)
+			_, err = KubernetesSecret(decrypted, SecretOptions{Path: "web"})
+			So(errors.Is(err, ErrInvalidSecret), ShouldBeTrue)
+		})
+
+		Convey("should keep values encrypted when sealed", func() {
+			var encrypted bytes.Buffer
+			_, err := Encrypt(strings.NewReader(`{"_public_key": "`+validPubKey+`", "db": {"password": "hunter2"}}`), &encrypted)
+			So(err, ShouldBeNil)
+
+			out, err := KubernetesSecret(encrypted.Bytes(), SecretOptions{Name: "db", Path: "db", Sealed: true})
+			So(err, ShouldBeNil)
+			So(string(out), ShouldContainSubstring, `"`+SealedPublicKeyAnnotation+`": "`+validPubKey+`"`)
+			So(string(out), ShouldContainSubstring, "stringData:\n  \"password\": \"EJ[")
+			So(string(out), ShouldNotContainSubstring, "hunter2")
+
+			_, err = KubernetesSecret(decrypted, SecretOptions{Name: "web", Path: "web", Sealed: true})
+			So(errors.Is(err, ErrNotEncrypted), ShouldBeTrue)
+
+			encrypted.Reset()
+			_, err = Encrypt(bytes.NewReader(decrypted), &encrypted)
+			So(err, ShouldBeNil)
+			_, err = KubernetesSecret(encrypted.Bytes(), SecretOptions{Name: "web", Path: "web", Sealed: true})
+			So(errors.Is(err, ErrInvalidSecret), ShouldBeTrue)
+		})
+	})
+}
+
+func TestSelectPath(t *testing.T) {
+	Convey("selectPath", t, func() {
+		root := map[string]interface{}{"a": map[string]interface{}{"b": "c"}}
+		value, err := selectPath(root, "a.b")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "c")
+		_, err = selectPath(root, "a.b.c")
+		So(err, ShouldNotBeNil)
+		So(strings.Contains(err.Error(), "a.b.c"), ShouldBeTrue)
+	})
+}
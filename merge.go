@@ -0,0 +1,51 @@
+package ejson
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/Shopify/ejson/json"
+)
+
+// MergeDocuments merges the changes made to an EJSON document in two
+// branches, ours and theirs, since their common ancestor, base, by path
+// rather than by line (see json.Merge3). No private key is needed: a value
+// changed by only one side is taken from that side, ciphertext and all. As
+// for Diff, opts says where the private keys may be found, and how to audit
+// their use; with them, values that were encrypted separately but have the
+// same plaintext are recognized as the same, rather than as a conflict. The
+// documents are named in opts.Files in the order base, ours, theirs.
+//
+// The merged document is returned even if there are conflicts, with the
+// conflicting values between git's conflict markers, and the conflicting
+// paths are returned as well. An empty base, as when both branches added
+// the document, is treated as an empty object.
+func MergeDocuments(base, ours, theirs io.Reader, opts DiffOptions) (merged []byte, conflicts []string, err error) {
+	baseData, err := io.ReadAll(base)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(bytes.TrimSpace(baseData)) == 0 {
+		baseData = []byte("{}")
+	}
+
+	sides := make([]*diffSide, 3)
+	for i, in := range []io.Reader{bytes.NewReader(baseData), ours, theirs} {
+		if sides[i], err = loadDiffSide(in, opts, i); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	normalize := func(side int, value []byte) []byte {
+		plaintext, known, err := sides[side].plaintext(value)
+		if err != nil || !known {
+			return value
+		}
+		return plaintext
+	}
+	merged, conflicts, err = json.Merge3(sides[json.MergeBase].data, sides[json.MergeOurs].data, sides[json.MergeTheirs].data, normalize)
+	if err := auditDiffSides(opts.Audit, err, sides...); err != nil {
+		return nil, nil, err
+	}
+	return merged, conflicts, nil
+}
@@ -0,0 +1,55 @@
+package ejson
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMergeDocuments(t *testing.T) {
+	Convey("MergeDocuments", t, func() {
+		encrypt := func(doc string) string {
+			var out bytes.Buffer
+			_, err := Encrypt(strings.NewReader(`{"_public_key": "`+validPubKey+`", `+doc+`}`), &out)
+			So(err, ShouldBeNil)
+			return out.String()
+		}
+		base := encrypt(`"a": "1"`)
+		ours := encrypt(`"a": "1", "b": "2"`)
+		theirs := strings.Replace(base, "}", `, "c": "3"}`, 1)
+
+		Convey("should merge additions on both sides without keys", func() {
+			merged, conflicts, err := MergeDocuments(strings.NewReader(base), strings.NewReader(ours), strings.NewReader(theirs), DiffOptions{})
+			So(err, ShouldBeNil)
+			So(conflicts, ShouldBeEmpty)
+			So(string(merged), ShouldContainSubstring, `"b": "EJ[`)
+			So(string(merged), ShouldContainSubstring, `"c": "3"`)
+			So(Check(bytes.NewReader(merged)), ShouldNotBeNil) // "c" was added unencrypted
+		})
+
+		Convey("should treat separate encryptions of the same value as a conflict without keys", func() {
+			ours := encrypt(`"a": "same"`)
+			theirs := encrypt(`"a": "same"`)
+			_, conflicts, err := MergeDocuments(strings.NewReader(base), strings.NewReader(ours), strings.NewReader(theirs), DiffOptions{})
+			So(err, ShouldBeNil)
+			So(conflicts, ShouldResemble, []string{"a"})
+
+			Convey("but not with them", func() {
+				_, conflicts, err := MergeDocuments(strings.NewReader(base), strings.NewReader(ours), strings.NewReader(theirs), DiffOptions{UserSuppliedPrivateKey: validPrivKey})
+				So(err, ShouldBeNil)
+				So(conflicts, ShouldBeEmpty)
+			})
+		})
+
+		Convey("should accept an empty base", func() {
+			_, conflicts, err := MergeDocuments(strings.NewReader(""), strings.NewReader(ours), strings.NewReader(theirs), DiffOptions{})
+			So(err, ShouldBeNil)
+			So(conflicts, ShouldResemble, []string{"a"})
+			_, conflicts, err = MergeDocuments(strings.NewReader(""), strings.NewReader(ours), strings.NewReader(theirs), DiffOptions{UserSuppliedPrivateKey: validPrivKey})
+			So(err, ShouldBeNil)
+			So(conflicts, ShouldBeEmpty)
+		})
+	})
+}
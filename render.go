@@ -0,0 +1,87 @@
+package ejson
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/template"
+
+	ejsonjson "github.com/Shopify/ejson/json"
+)
+
+// templateFuncs are the helpers available to templates run by RenderTemplate,
+// in addition to text/template's own.
+var templateFuncs = template.FuncMap{
+	// base64 encodes a value with standard base64.
+	"base64": func(v interface{}) string {
+		return base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(v)))
+	},
+	// json encodes a value as JSON.
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	// quote quotes a value as a double-quoted string, with Go's escapes.
+	"quote": func(v interface{}) string {
+		return strconv.Quote(fmt.Sprint(v))
+	},
+	// required fails with the given message if a value is null or empty, as
+	// in {{ .db.password | required "db.password must be set" }}.
+	"required": func(msg string, v interface{}) (interface{}, error) {
+		if v == nil || v == "" {
+			return nil, fmt.Errorf("required value missing: %s", msg)
+		}
+		return v, nil
+	},
+	// env returns the value of an environment variable.
+	"env": os.Getenv,
+}
+
+// RenderTemplate executes a text/template, given as templateText, with a
+// decrypted EJSON document as its data, writing the result to out. Besides
+// text/template's own functions, templates can use base64, json, quote,
+// required and env. Referring to a key that isn't in the document is an
+// error, as is passing a null or empty value to required. Numbers are
+// rendered as they are written in the document.
+func RenderTemplate(out io.Writer, name, templateText string, decrypted []byte) error {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(templateText)
+	if err != nil {
+		return err
+	}
+
+	decrypted, err = ejsonjson.CollapseMultilineStringLiterals(decrypted)
+	if err != nil {
+		return err
+	}
+	var data interface{}
+	dec := json.NewDecoder(bytes.NewReader(decrypted))
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil {
+		return err
+	}
+
+	return tmpl.Execute(out, data)
+}
+
+// RenderFile decrypts the EJSON file at dataPath, as DecryptFileWithOptions
+// does, and renders the template file at templatePath with it, as
+// RenderTemplate does, returning the result.
+func RenderFile(templatePath, dataPath, keydir string, userSuppliedPrivateKey string, opts DecryptOptions) ([]byte, error) {
+	templateText, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := DecryptFileWithOptions(dataPath, keydir, userSuppliedPrivateKey, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var outBuffer bytes.Buffer
+	err = RenderTemplate(&outBuffer, filepath.Base(templatePath), string(templateText), decrypted)
+	return outBuffer.Bytes(), err
+}
@@ -0,0 +1,55 @@
+package ejson
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRender(t *testing.T) {
+	Convey("RenderTemplate", t, func() {
+		decrypted := []byte(`{"_public_key": "` + validPubKey + `", "db": {"user": "app", "password": "p\"w", "port": 5432}, "empty": ""}`)
+		render := func(text string) (string, error) {
+			var out bytes.Buffer
+			err := RenderTemplate(&out, "test", text, decrypted)
+			return out.String(), err
+		}
+
+		Convey("should expose the document and helpers", func() {
+			t.Setenv("EJSON_TEST_HOST", "db.internal")
+			out, err := render(`{{ env "EJSON_TEST_HOST" }}:{{ .db.port }} {{ .db.user }} {{ .db.password | quote }} {{ .db.user | base64 }} {{ .db | json }}`)
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, `db.internal:5432 app "p\"w" YXBw {"password":"p\"w","port":5432,"user":"app"}`)
+		})
+
+		Convey("should fail on missing and required values", func() {
+			_, err := render(`{{ .db.host }}`)
+			So(err, ShouldNotBeNil)
+			_, err = render(`{{ .empty | required "empty must be set" }}`)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "required value missing: empty must be set")
+		})
+	})
+
+	Convey("RenderFile", t, func() {
+		dir := t.TempDir()
+		dataPath := filepath.Join(dir, "secrets.ejson")
+		templatePath := filepath.Join(dir, "pgbouncer.ini.tmpl")
+		So(os.WriteFile(dataPath, []byte(`{"_public_key": "`+validPubKey+`", "password": "hunter2"}`), 0o600), ShouldBeNil)
+		_, err := EncryptFileInPlace(dataPath)
+		So(err, ShouldBeNil)
+		So(os.WriteFile(templatePath, []byte("password = {{ .password }}\n"), 0o600), ShouldBeNil)
+
+		out, err := RenderFile(templatePath, dataPath, "", validPrivKey, DecryptOptions{})
+		So(err, ShouldBeNil)
+		So(string(out), ShouldEqual, "password = hunter2\n")
+
+		So(os.WriteFile(templatePath, []byte("{{ .password "), 0o600), ShouldBeNil)
+		_, err = RenderFile(templatePath, dataPath, "", validPrivKey, DecryptOptions{})
+		So(strings.Contains(err.Error(), "pgbouncer.ini.tmpl"), ShouldBeTrue)
+	})
+}
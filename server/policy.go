@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// anyKey in a Policy allows a client to decrypt documents with any public
+// key.
+const anyKey = "*"
+
+// clientNamePrefixes are the sources of the names returned by ClientNames.
+var clientNamePrefixes = []string{"cn:", "dns:", "uri:", "uid:"}
+
+// Policy maps the names of clients (see ClientNames) to the hex-encoded
+// public keys of the documents they may decrypt, or "*" for any document.
+type Policy map[string][]string
+
+// LoadPolicy reads a Policy from a JSON file, such as
+//
+//	{"dns:build.example.com": ["<public key>", ...], "uid:0": ["*"]}
+//
+// Every client name must have one of the prefixes given by ClientNames.
+// Public keys may be written in either case.
+func LoadPolicy(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for client, keys := range policy {
+		if !hasClientNamePrefix(client) {
+			return nil, fmt.Errorf("%s: client %q must begin with cn:, dns:, uri: or uid:", path, client)
+		}
+		for i, key := range keys {
+			if key == anyKey {
+				continue
+			}
+			if b, err := hex.DecodeString(key); err != nil || len(b) != 32 {
+				return nil, fmt.Errorf("%s: %s: invalid public key %q", path, client, key)
+			}
+			// Allows compares keys as hex.EncodeToString writes them.
+			keys[i] = strings.ToLower(key)
+		}
+	}
+	return policy, nil
+}
+
+func hasClientNamePrefix(name string) bool {
+	for _, prefix := range clientNamePrefixes {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether a client known by the given names may decrypt
+// documents with the public key pub. A nil Policy allows everything.
+func (p Policy) Allows(names []string, pub [32]byte) bool {
+	if p == nil {
+		return true
+	}
+	want := hex.EncodeToString(pub[:])
+	for _, name := range names {
+		for _, key := range p[name] {
+			if key == anyKey || key == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,331 @@
+// Package server decrypts EJSON documents over HTTP, so that private keys can
+// be kept on one host instead of being copied to every machine that needs
+// secrets.
+//
+// Clients POST a document to /v1/decrypt and get back the decrypted document
+// or, given one or more path query parameters, just the values at those
+// paths. Which clients may decrypt documents for which public keys is decided
+// by a Policy, and each request is recorded in an audit log, without the
+// values themselves.
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/ejson"
+	"github.com/Shopify/ejson/crypto"
+	ejsonjson "github.com/Shopify/ejson/json"
+)
+
+// DecryptPath is the path at which documents are decrypted.
+const DecryptPath = "/v1/decrypt"
+
+// defaultMaxDocumentSize is used when Config.MaxDocumentSize is zero.
+const defaultMaxDocumentSize = 1 << 20
+
+// Config describes a Server.
+type Config struct {
+	// Keys looks up the private keys of the documents to decrypt.
+	Keys ejson.KeyProvider
+	// Policy, if not nil, says which clients may decrypt documents with which
+	// public keys. If nil, any client that can connect may decrypt anything.
+	Policy Policy
+	// TrustedSigners, if not empty, makes the server refuse documents that
+	// aren't signed by one of these keys (see ejson.Sign).
+	TrustedSigners []ed25519.PublicKey
+	// Audit, if not nil, is called with an ejson.AuditEvent for each
+	// request, naming the client in its Client field. A request is refused
+	// if its event can't be recorded.
+	Audit ejson.AuditFunc
+	// MaxDocumentSize limits the size of the documents clients may send, in
+	// bytes. It is 1 MiB if zero.
+	MaxDocumentSize int64
+}
+
+// Server is an http.Handler that decrypts EJSON documents.
+type Server struct {
+	config Config
+}
+
+// New returns a Server with the given configuration.
+func New(config Config) *Server {
+	if config.MaxDocumentSize == 0 {
+		config.MaxDocumentSize = defaultMaxDocumentSize
+	}
+	return &Server{config: config}
+}
+
+// Serve accepts connections on l and serves them until ctx is done, then
+// shuts down gracefully. It returns nil after a graceful shutdown.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	srv := &http.Server{
+		Handler:           s,
+		ConnContext:       ConnContext,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- srv.Serve(l) }()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
+
+// ServeHTTP handles a request to decrypt a document.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != DecryptPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "only POST is allowed")
+		return
+	}
+
+	event := ejson.NewAuditEvent("")
+	event.Client = ClientNames(r)
+	status, err := s.decrypt(w, r, &event)
+	if err != nil {
+		message := err.Error()
+		if status == http.StatusInternalServerError {
+			// Internal errors may mention the server's own files.
+			message = "internal error"
+		}
+		writeError(w, status, message)
+	}
+}
+
+// decrypt decrypts the document in the request and writes the response. If
+// it fails, it returns the status to respond with and the error, and writes
+// nothing. The request is audited as event: by ejson.DecryptWithOptions, if
+// it gets that far, or else here.
+func (s *Server) decrypt(w http.ResponseWriter, r *http.Request, event *ejson.AuditEvent) (int, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.config.MaxDocumentSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return s.refuse(event, http.StatusRequestEntityTooLarge, fmt.Errorf("document is larger than %d bytes", tooLarge.Limit))
+	} else if err != nil {
+		return s.refuse(event, http.StatusBadRequest, err)
+	}
+	data, err := ejsonjson.CollapseMultilineStringLiterals(body)
+	if err != nil {
+		return s.refuse(event, http.StatusBadRequest, err)
+	}
+	pub, err := ejsonjson.ExtractPublicKey(data)
+	if err != nil {
+		return s.refuse(event, http.StatusBadRequest, err)
+	}
+	event.PublicKey = fmt.Sprintf("%x", pub)
+
+	if !s.config.Policy.Allows(event.Client, pub) {
+		return s.refuse(event, http.StatusForbidden, fmt.Errorf("client may not decrypt documents for %x", pub))
+	}
+
+	leaves, err := ejsonjson.Leaves(data)
+	if err != nil {
+		return s.refuse(event, http.StatusBadRequest, err)
+	}
+	paths := r.URL.Query()["path"]
+	for _, p := range paths {
+		if !anySelected(leaves, []string{p}) {
+			return s.refuse(event, http.StatusBadRequest, fmt.Errorf("%s is not in the document", p))
+		}
+	}
+
+	opts := ejson.DecryptOptions{
+		TrustedSigners: s.config.TrustedSigners,
+		Keys:           s.config.Keys,
+		Paths:          paths,
+	}
+	if s.config.Audit != nil {
+		opts.Audit = func(decrypted ejson.AuditEvent) error {
+			decrypted.Client = event.Client
+			return s.config.Audit(decrypted)
+		}
+	}
+	var decrypted bytes.Buffer
+	err = ejson.DecryptWithOptions(bytes.NewReader(data), &decrypted, "", "", opts)
+	if err != nil {
+		return statusForError(err), clientError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(paths) == 0 {
+		w.Write(decrypted.Bytes())
+		return http.StatusOK, nil
+	}
+	response, err := selectedValues(decrypted.Bytes(), paths)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	w.Write(response)
+	return http.StatusOK, nil
+}
+
+// refuse records a request that was refused before anything was decrypted,
+// and returns its status and error.
+func (s *Server) refuse(event *ejson.AuditEvent, status int, err error) (int, error) {
+	if s.config.Audit != nil {
+		event.Outcome = ejson.AuditFailed
+		if status == http.StatusForbidden {
+			event.Outcome = ejson.AuditDenied
+		}
+		event.Error = err.Error()
+		// The request has failed anyway, so there's nothing more to do if
+		// the event can't be recorded.
+		s.config.Audit(*event)
+	}
+	return status, err
+}
+
+// selectedValues returns a JSON object mapping the path of each leaf of a
+// document that is selected by paths to its value.
+func selectedValues(data []byte, paths []string) ([]byte, error) {
+	leaves, err := ejsonjson.Leaves(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, leaf := range leaves {
+		if !selected(leaf.Path, paths) {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(leaf.Path)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(leaf.Value)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes(), nil
+}
+
+// selected reports whether the value at path is at or under one of paths,
+// or whether paths is empty.
+func selected(path string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, p := range paths {
+		if path == p || strings.HasPrefix(path, p+".") || strings.HasPrefix(path, p+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+func anySelected(leaves []ejsonjson.Leaf, paths []string) bool {
+	for _, leaf := range leaves {
+		if selected(leaf.Path, paths) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusForError chooses the response status for a decryption error.
+func statusForError(err error) int {
+	var (
+		syntaxErr     *ejsonjson.SyntaxError
+		jsonSyntaxErr *json.SyntaxError
+	)
+	switch {
+	case errors.As(err, &syntaxErr),
+		errors.As(err, &jsonSyntaxErr),
+		errors.Is(err, ejsonjson.ErrPublicKeyMissing),
+		errors.Is(err, ejsonjson.ErrPublicKeyInvalid),
+		errors.Is(err, ejsonjson.ErrPolicyInvalid),
+		errors.Is(err, ejsonjson.ErrEncryptLiteralsInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, ejson.ErrKeyNotFound),
+		errors.Is(err, ejson.ErrKeyMismatch),
+		errors.Is(err, ejson.ErrUnsigned),
+		errors.Is(err, ejson.ErrSignatureInvalid),
+		errors.Is(err, ejson.ErrUntrustedSigner),
+		errors.Is(err, crypto.ErrMalformedCiphertext),
+		errors.Is(err, crypto.ErrUnsupportedSchema),
+		errors.Is(err, crypto.ErrDecryptionFailed),
+		errors.Is(err, crypto.ErrValueTooLarge):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// clientError returns the error to report to the client, which shouldn't
+// learn where the server keeps its keys.
+func clientError(err error) error {
+	if errors.Is(err, ejson.ErrKeyNotFound) {
+		return ejson.ErrKeyNotFound
+	}
+	return err
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{message})
+}
+
+type connContextKey struct{}
+
+// ConnContext records the connection in a request's context, so that the
+// client's user can be found when it connects over a Unix socket. It is meant
+// for http.Server.ConnContext, and is set by Serve.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// ClientNames returns the names by which the client making a request is
+// known, which a Policy refers to. Each name is prefixed with its source, so
+// that one kind of name can't pass for another. A client authenticated with
+// a verified TLS certificate is known as cn:<common name>, and by its DNS and
+// URI subject alternative names as dns:<name> and uri:<URI>. On Linux, a
+// client connected over a Unix socket is known as uid:<its user ID>.
+func ClientNames(r *http.Request) []string {
+	var names []string
+	// Only a certificate that was verified against the client CAs names the
+	// client; with tls.RequestClientCert, PeerCertificates may be anything.
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		if cert.Subject.CommonName != "" {
+			names = append(names, "cn:"+cert.Subject.CommonName)
+		}
+		for _, name := range cert.DNSNames {
+			names = append(names, "dns:"+name)
+		}
+		for _, uri := range cert.URIs {
+			names = append(names, "uri:"+uri.String())
+		}
+	}
+	if c, ok := r.Context().Value(connContextKey{}).(*net.UnixConn); ok {
+		if uid, ok := peerUID(c); ok {
+			names = append(names, "uid:"+strconv.Itoa(uid))
+		}
+	}
+	return names
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/ejson"
+	"github.com/Shopify/ejson/crypto"
+	ejsonjson "github.com/Shopify/ejson/json"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func encryptedDocument(t *testing.T) (doc []byte, pub, priv string) {
+	pub, priv, err := ejson.GenerateKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	plain := `{"_public_key": "` + pub + `", "db": {"password": "hunter2", "user": "app"}, "api_key": "k"}`
+	if _, err := ejson.Encrypt(strings.NewReader(plain), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes(), pub, priv
+}
+
+func auditEntries(buf *bytes.Buffer) []ejson.AuditEvent {
+	var entries []ejson.AuditEvent
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry ejson.AuditEvent
+		if json.Unmarshal([]byte(line), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func TestServer(t *testing.T) {
+	Convey("Server", t, func() {
+		doc, pub, priv := encryptedDocument(t)
+		var audit bytes.Buffer
+		config := Config{
+			Keys: ejson.KeyProviderFunc(func(p [32]byte) (string, error) {
+				return priv, nil
+			}),
+			Audit: ejson.JSONAuditLog(&audit),
+		}
+		post := func(s *Server, target string, body []byte, names ...string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+			if len(names) > 0 {
+				cert := &x509.Certificate{Subject: pkix.Name{CommonName: names[0]}, DNSNames: names[1:]}
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			return rec
+		}
+
+		Convey("should decrypt a whole document", func() {
+			rec := post(New(config), DecryptPath, doc)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldContainSubstring, `"password": "hunter2"`)
+
+			entries := auditEntries(&audit)
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].Outcome, ShouldEqual, ejson.AuditDecrypted)
+			So(entries[0].PublicKey, ShouldEqual, pub)
+			So(entries[0].Paths, ShouldResemble, []string{"api_key", "db.password", "db.user"})
+			So(audit.String(), ShouldNotContainSubstring, "hunter2")
+		})
+
+		Convey("should decrypt only the selected paths", func() {
+			rec := post(New(config), DecryptPath+"?path=db.password", doc)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldEqual, `{"db.password":"hunter2"}`+"\n")
+			So(auditEntries(&audit)[0].Paths, ShouldResemble, []string{"db.password"})
+
+			rec = post(New(config), DecryptPath+"?path=db.host", doc)
+			So(rec.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("should authorize clients by public key", func() {
+			config.Policy = Policy{"cn:worker": {pub}, "cn:other": {strings.Repeat("0", 64)}, "dns:admin": {"*"}}
+			s := New(config)
+			So(post(s, DecryptPath, doc, "worker").Code, ShouldEqual, http.StatusOK)
+			So(post(s, DecryptPath, doc, "x", "admin").Code, ShouldEqual, http.StatusOK)
+			So(post(s, DecryptPath, doc, "other").Code, ShouldEqual, http.StatusForbidden)
+			So(post(s, DecryptPath, doc).Code, ShouldEqual, http.StatusForbidden)
+
+			entries := auditEntries(&audit)
+			So(len(entries), ShouldEqual, 4)
+			So(entries[2].Client, ShouldResemble, []string{"cn:other"})
+			So(entries[2].Outcome, ShouldEqual, ejson.AuditDenied)
+			So(entries[2].Paths, ShouldBeEmpty)
+		})
+
+		Convey("should not confuse certificate names with other kinds of name", func() {
+			config.Policy = Policy{"uid:0": {"*"}, "cn:worker": {"*"}}
+			s := New(config)
+			So(post(s, DecryptPath, doc, "uid:0").Code, ShouldEqual, http.StatusForbidden)
+
+			Convey("or trust certificates that weren't verified", func() {
+				req := httptest.NewRequest(http.MethodPost, DecryptPath, bytes.NewReader(doc))
+				cert := &x509.Certificate{Subject: pkix.Name{CommonName: "worker"}}
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+				rec := httptest.NewRecorder()
+				s.ServeHTTP(rec, req)
+				So(rec.Code, ShouldEqual, http.StatusForbidden)
+			})
+		})
+
+		Convey("should report errors without revealing where keys are kept", func() {
+			config.Keys = ejson.Keydir(t.TempDir())
+			rec := post(New(config), DecryptPath, doc)
+			So(rec.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			So(rec.Body.String(), ShouldEqual, `{"error":"private key not found"}`+"\n")
+			So(auditEntries(&audit)[0].Outcome, ShouldEqual, ejson.AuditFailed)
+
+			So(post(New(config), DecryptPath, []byte(`{"a": "b"}`)).Code, ShouldEqual, http.StatusBadRequest)
+			So(post(New(config), DecryptPath, []byte(`{"a": `)).Code, ShouldEqual, http.StatusBadRequest)
+			config.MaxDocumentSize = 10
+			So(post(New(config), DecryptPath, doc).Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+
+		Convey("should refuse requests that can't be audited", func() {
+			config.Audit = func(ejson.AuditEvent) error { return errors.New("disk full") }
+			rec := post(New(config), DecryptPath, doc)
+			So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+			So(rec.Body.String(), ShouldNotContainSubstring, "hunter2")
+		})
+
+		Convey("should only accept POSTs to the decrypt path", func() {
+			s := New(config)
+			So(post(s, "/", doc).Code, ShouldEqual, http.StatusNotFound)
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DecryptPath, nil))
+			So(rec.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+
+		Convey("should serve over a Unix socket", func() {
+			socket := filepath.Join(t.TempDir(), "ejson.sock")
+			l, err := net.Listen("unix", socket)
+			So(err, ShouldBeNil)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- New(config).Serve(ctx, l) }()
+
+			client := &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+				},
+			}}
+			resp, err := client.Post("http://ejson"+DecryptPath+"?path=api_key", "application/json", bytes.NewReader(doc))
+			So(err, ShouldBeNil)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			So(string(body), ShouldEqual, `{"api_key":"k"}`+"\n")
+
+			cancel()
+			So(<-done, ShouldBeNil)
+			if runtime.GOOS == "linux" {
+				So(auditEntries(&audit)[0].Client, ShouldResemble, []string{"uid:" + strconv.Itoa(os.Getuid())})
+			}
+		})
+	})
+}
+
+func TestStatusForError(t *testing.T) {
+	Convey("statusForError", t, func() {
+		_, syntaxErr := ejsonjson.ExtractPublicKey([]byte(`{"a": `))
+		So(syntaxErr, ShouldNotBeNil)
+		for _, c := range []struct {
+			err    error
+			status int
+		}{
+			{syntaxErr, http.StatusBadRequest},
+			{&json.SyntaxError{}, http.StatusBadRequest},
+			{ejsonjson.ErrPublicKeyMissing, http.StatusBadRequest},
+			{ejsonjson.ErrPublicKeyInvalid, http.StatusBadRequest},
+			{ejsonjson.ErrPolicyInvalid, http.StatusBadRequest},
+			{ejsonjson.ErrEncryptLiteralsInvalid, http.StatusBadRequest},
+			{ejson.ErrKeyNotFound, http.StatusUnprocessableEntity},
+			{ejson.ErrKeyMismatch, http.StatusUnprocessableEntity},
+			{ejson.ErrUnsigned, http.StatusUnprocessableEntity},
+			{ejson.ErrSignatureInvalid, http.StatusUnprocessableEntity},
+			{ejson.ErrUntrustedSigner, http.StatusUnprocessableEntity},
+			{crypto.ErrMalformedCiphertext, http.StatusUnprocessableEntity},
+			{crypto.ErrUnsupportedSchema, http.StatusUnprocessableEntity},
+			{crypto.ErrDecryptionFailed, http.StatusUnprocessableEntity},
+			{crypto.ErrValueTooLarge, http.StatusUnprocessableEntity},
+			{errors.New("disk on fire"), http.StatusInternalServerError},
+		} {
+			So(statusForError(fmt.Errorf("wrapped: %w", c.err)), ShouldEqual, c.status)
+		}
+	})
+}
+
+func TestLoadPolicy(t *testing.T) {
+	Convey("LoadPolicy", t, func() {
+		path := filepath.Join(t.TempDir(), "policy.json")
+		So(os.WriteFile(path, []byte(`{"dns:worker": ["`+strings.Repeat("AB", 32)+`"], "uid:0": ["*"]}`), 0o600), ShouldBeNil)
+		policy, err := LoadPolicy(path)
+		So(err, ShouldBeNil)
+		So(policy, ShouldHaveLength, 2)
+		var pub [32]byte
+		for i := range pub {
+			pub[i] = 0xab
+		}
+		So(policy.Allows([]string{"dns:worker"}, pub), ShouldBeTrue)
+
+		So(os.WriteFile(path, []byte(`{"dns:worker": ["nope"]}`), 0o600), ShouldBeNil)
+		_, err = LoadPolicy(path)
+		So(err, ShouldNotBeNil)
+
+		So(os.WriteFile(path, []byte(`{"worker": ["*"]}`), 0o600), ShouldBeNil)
+		_, err = LoadPolicy(path)
+		So(err, ShouldNotBeNil)
+	})
+}
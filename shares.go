@@ -0,0 +1,176 @@
+package ejson
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/ejson/crypto"
+	"github.com/Shopify/ejson/json"
+)
+
+// keyShareHeader is the first line of every key share, identifying the format.
+const keyShareHeader = "ejson key share v1"
+
+// ErrInvalidKeyShare means that a key share couldn't be parsed, or that its
+// checksum didn't match its contents.
+var ErrInvalidKeyShare = errors.New("invalid key share")
+
+// KeyShare is one share of a private key split by SplitKey. It describes
+// itself, so that shares handed out for safekeeping can be recognized and
+// recombined long after they were made.
+type KeyShare struct {
+	// PublicKey is the public key matching the private key that was split.
+	PublicKey string
+	// Index is the number of this share, from 1 to Shares.
+	Index     int
+	Shares    int
+	Threshold int
+	Data      []byte
+}
+
+// SplitKey splits the private key for pub (found as by Decrypt) into the given
+// number of shares, any threshold of which can be recombined by CombineKey.
+func SplitKey(pub, keydir, userSuppliedPrivateKey string, shares, threshold int) ([]KeyShare, error) {
+	pubkey, err := json.ParsePublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	privkey, err := findPrivateKey(pubkey, keydir, userSuppliedPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	split, err := crypto.SplitSecret(privkey[:], shares, threshold)
+	if err != nil {
+		return nil, err
+	}
+	keyShares := make([]KeyShare, len(split))
+	for i, share := range split {
+		keyShares[i] = KeyShare{
+			PublicKey: fmt.Sprintf("%x", pubkey),
+			Index:     int(share.X),
+			Shares:    shares,
+			Threshold: threshold,
+			Data:      share.Y,
+		}
+	}
+	return keyShares, nil
+}
+
+// CombineKey reconstructs a private key from the shares made by SplitKey,
+// checking that it matches the public key the shares were made for. The keys
+// are returned in the same form as GenerateKeypair.
+func CombineKey(shares []KeyShare) (pub string, priv string, err error) {
+	if len(shares) == 0 {
+		return "", "", fmt.Errorf("%w: no shares given", crypto.ErrInvalidShares)
+	}
+	first := shares[0]
+	split := make([]crypto.Share, len(shares))
+	for i, share := range shares {
+		if share.PublicKey != first.PublicKey || share.Shares != first.Shares || share.Threshold != first.Threshold {
+			return "", "", fmt.Errorf("%w: share %d was not split from the same key as share %d", crypto.ErrInvalidShares, share.Index, first.Index)
+		}
+		if share.Index < 1 || share.Index > share.Shares {
+			return "", "", fmt.Errorf("%w: share %d of %d", crypto.ErrInvalidShares, share.Index, share.Shares)
+		}
+		split[i] = crypto.Share{X: byte(share.Index), Y: share.Data}
+	}
+	if len(shares) < first.Threshold {
+		return "", "", fmt.Errorf("%w: %d shares are needed, but only %d were given", crypto.ErrInvalidShares, first.Threshold, len(shares))
+	}
+
+	pubkey, err := json.ParsePublicKey(first.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := crypto.CombineSecret(split)
+	if err != nil {
+		return "", "", err
+	}
+	if len(secret) != 32 {
+		return "", "", ErrInvalidPrivateKey
+	}
+	var kp crypto.Keypair
+	kp.FromPrivate([32]byte(secret))
+	if kp.Public != pubkey {
+		return "", "", fmt.Errorf("%w %x", ErrKeyMismatch, pubkey)
+	}
+	return kp.PublicString(), kp.PrivateString(), nil
+}
+
+// MarshalText encodes the share in a line-based text format, ending with a
+// checksum so that a mistyped share is detected on its own.
+func (s KeyShare) MarshalText() ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Combine %d of the %d shares of this ejson private key with `ejson keys combine`.\n", s.Threshold, s.Shares)
+	body := s.body()
+	buf.WriteString(body)
+	fmt.Fprintf(&buf, "checksum: %s\n", keyShareChecksum(body))
+	return buf.Bytes(), nil
+}
+
+// UnmarshalText decodes a share encoded by MarshalText, verifying its
+// checksum. Lines beginning with "#" are ignored.
+func (s *KeyShare) UnmarshalText(text []byte) error {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) != 6 || lines[0] != keyShareHeader {
+		return fmt.Errorf("%w: not an ejson key share", ErrInvalidKeyShare)
+	}
+
+	fields := make(map[string]string, 5)
+	for i, name := range []string{"public-key", "share", "threshold", "data", "checksum"} {
+		value, ok := strings.CutPrefix(lines[i+1], name+": ")
+		if !ok {
+			return fmt.Errorf("%w: expected %q on line %d", ErrInvalidKeyShare, name, i+2)
+		}
+		fields[name] = value
+	}
+
+	var share KeyShare
+	var err error
+	share.PublicKey = fields["public-key"]
+	index, shares, ok := strings.Cut(fields["share"], " of ")
+	if !ok {
+		return fmt.Errorf("%w: invalid share number %q", ErrInvalidKeyShare, fields["share"])
+	}
+	if share.Index, err = strconv.Atoi(index); err != nil {
+		return fmt.Errorf("%w: invalid share number %q", ErrInvalidKeyShare, fields["share"])
+	}
+	if share.Shares, err = strconv.Atoi(shares); err != nil {
+		return fmt.Errorf("%w: invalid share number %q", ErrInvalidKeyShare, fields["share"])
+	}
+	if share.Threshold, err = strconv.Atoi(fields["threshold"]); err != nil {
+		return fmt.Errorf("%w: invalid threshold %q", ErrInvalidKeyShare, fields["threshold"])
+	}
+	if share.Data, err = hex.DecodeString(fields["data"]); err != nil {
+		return fmt.Errorf("%w: invalid data", ErrInvalidKeyShare)
+	}
+	if keyShareChecksum(share.body()) != fields["checksum"] {
+		return fmt.Errorf("%w: checksum mismatch", ErrInvalidKeyShare)
+	}
+	*s = share
+	return nil
+}
+
+// body returns the lines of the encoded share covered by its checksum.
+func (s KeyShare) body() string {
+	return fmt.Sprintf("%s\npublic-key: %s\nshare: %d of %d\nthreshold: %d\ndata: %x\n",
+		keyShareHeader, s.PublicKey, s.Index, s.Shares, s.Threshold, s.Data)
+}
+
+func keyShareChecksum(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:4])
+}
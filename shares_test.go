@@ -0,0 +1,63 @@
+package ejson
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/ejson/crypto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKeyShares(t *testing.T) {
+	Convey("Key shares", t, func() {
+		shares, err := SplitKey(validPubKey, "", validPrivKey, 5, 3)
+		So(err, ShouldBeNil)
+		So(len(shares), ShouldEqual, 5)
+
+		Convey("should round trip through their text format", func() {
+			text, err := shares[1].MarshalText()
+			So(err, ShouldBeNil)
+			So(string(text), ShouldContainSubstring, "ejson key share v1\npublic-key: "+validPubKey+"\nshare: 2 of 5\nthreshold: 3\n")
+
+			var parsed KeyShare
+			So(parsed.UnmarshalText(text), ShouldBeNil)
+			So(parsed, ShouldResemble, shares[1])
+
+			Convey("and detect mistyped shares", func() {
+				data := strings.Replace(string(text), "threshold: 3", "threshold: 2", 1)
+				So(errors.Is(parsed.UnmarshalText([]byte(data)), ErrInvalidKeyShare), ShouldBeTrue)
+				So(errors.Is(parsed.UnmarshalText([]byte("hello")), ErrInvalidKeyShare), ShouldBeTrue)
+			})
+		})
+
+		Convey("should recombine into the private key", func() {
+			pub, priv, err := CombineKey([]KeyShare{shares[4], shares[0], shares[2]})
+			So(err, ShouldBeNil)
+			So(pub, ShouldEqual, validPubKey)
+			So(priv, ShouldEqual, validPrivKey)
+		})
+
+		Convey("should refuse too few shares", func() {
+			_, _, err := CombineKey(shares[:2])
+			So(errors.Is(err, crypto.ErrInvalidShares), ShouldBeTrue)
+		})
+
+		Convey("should refuse shares of different keys", func() {
+			pub, priv, err := GenerateKeypair()
+			So(err, ShouldBeNil)
+			other, err := SplitKey(pub, "", priv, 5, 3)
+			So(err, ShouldBeNil)
+			_, _, err = CombineKey([]KeyShare{shares[0], shares[1], other[2]})
+			So(errors.Is(err, crypto.ErrInvalidShares), ShouldBeTrue)
+		})
+
+		Convey("should detect a key that doesn't match", func() {
+			forged := shares[2]
+			forged.Data = append([]byte(nil), forged.Data...)
+			forged.Data[10] ^= 0xff
+			_, _, err := CombineKey([]KeyShare{shares[0], shares[1], forged})
+			So(errors.Is(err, ErrKeyMismatch), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,240 @@
+package ejson
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Shopify/ejson/crypto"
+	"github.com/Shopify/ejson/internal/atomicfile"
+	ejsonjson "github.com/Shopify/ejson/json"
+)
+
+// signatureAlgorithm is the only value of the "alg" field of a signature.
+const signatureAlgorithm = "ed25519"
+
+// signatureContext is prepended to the canonical form of a document before it
+// is signed, so that a signature over a document can't be mistaken for a
+// signature over anything else.
+const signatureContext = "ejson signature v1\n"
+
+// ErrUnsigned means that a document which was required to be signed has no
+// signature.
+var ErrUnsigned = errors.New("document is not signed")
+
+// ErrSignatureInvalid means that a document's signature is malformed, or
+// doesn't match the document: it has been changed since it was signed.
+var ErrSignatureInvalid = errors.New("signature is invalid")
+
+// ErrUntrustedSigner means that a document was validly signed, but not by one
+// of the trusted keys.
+var ErrUntrustedSigner = errors.New("document is signed by an untrusted key")
+
+// signatureBlock is the value of the json.SignatureField of a signed
+// document.
+type signatureBlock struct {
+	Alg string `json:"alg"`
+	Key string `json:"key"`
+	Sig string `json:"sig"`
+}
+
+// GenerateSigningKeypair creates a new ed25519 keypair for signing documents.
+// It returns the public key and the private key's seed as hex-encoded strings.
+func GenerateSigningKeypair() (pub string, priv string, err error) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(pubKey), hex.EncodeToString(privKey.Seed()), nil
+}
+
+// LoadSigningKey reads a signing key from a file: either a hex-encoded seed,
+// as printed by GenerateSigningKeypair, or an OpenSSH ed25519 private key. The
+// passphrase is only needed if the SSH key is encrypted.
+func LoadSigningKey(path string, passphrase []byte) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.ParseSigningKey(data, passphrase)
+}
+
+// LoadTrustedKeys reads the public keys of trusted signers from the files in
+// dir. Each file may hold any number of keys, one per line, either
+// hex-encoded or in the format of an authorized_keys file. Hidden files are
+// ignored.
+func LoadTrustedKeys(dir string) ([]ed25519.PublicKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var trusted []ed25519.PublicKey
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := crypto.ParseVerifyingKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		trusted = append(trusted, keys...)
+	}
+	if len(trusted) == 0 {
+		return nil, fmt.Errorf("no trusted keys found in %s", dir)
+	}
+	return trusted, nil
+}
+
+// Sign reads an EJSON document from 'in', signs it with key, and writes the
+// signed document to 'out'. The signature covers a canonical form of the whole
+// document, ciphertexts included, and is stored in its json.SignatureField,
+// replacing any earlier signature. The document must be fully encrypted, since
+// encrypting it afterwards would invalidate the signature.
+// Returns the number of bytes written and any error that might have occurred.
+func Sign(in io.Reader, out io.Writer, key ed25519.PrivateKey) (int, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return -1, err
+	}
+
+	data, err = ejsonjson.CollapseMultilineStringLiterals(data)
+	if err != nil {
+		return -1, err
+	}
+
+	if err = Check(bytes.NewReader(data)); err != nil {
+		return -1, err
+	}
+
+	message, err := signedMessage(data)
+	if err != nil {
+		return -1, err
+	}
+	block, err := json.Marshal(signatureBlock{
+		Alg: signatureAlgorithm,
+		Key: hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Sig: base64.StdEncoding.EncodeToString(ed25519.Sign(key, message)),
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	signed, err := ejsonjson.SetField(data, ejsonjson.SignatureField, block)
+	if err != nil {
+		return -1, err
+	}
+	return out.Write(signed)
+}
+
+// SignFileInPlace signs the EJSON file at filePath, as Sign does, replacing it
+// as EncryptFileInPlace does.
+func SignFileInPlace(filePath string, key ed25519.PrivateKey) (int, error) {
+	var written int
+	_, err := atomicfile.Update(filePath, func(data []byte) ([]byte, error) {
+		var outBuffer bytes.Buffer
+		n, err := Sign(bytes.NewReader(data), &outBuffer, key)
+		written = n
+		return outBuffer.Bytes(), err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return written, nil
+}
+
+// VerifySignature reads an EJSON document from 'in' and checks its signature,
+// returning the hex-encoded public key of the signer. If trusted is not
+// empty, the signer must be one of the trusted keys.
+func VerifySignature(in io.Reader, trusted []ed25519.PublicKey) (signer string, err error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return "", err
+	}
+
+	data, err = ejsonjson.CollapseMultilineStringLiterals(data)
+	if err != nil {
+		return "", err
+	}
+	return verifySignature(data, trusted)
+}
+
+// VerifySignatureFile is like VerifySignature, but reads the document from the
+// file at filePath.
+func VerifySignatureFile(filePath string, trusted []ed25519.PublicKey) (signer string, err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return VerifySignature(file, trusted)
+}
+
+func verifySignature(data []byte, trusted []ed25519.PublicKey) (string, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return "", err
+	}
+	raw, ok := obj[ejsonjson.SignatureField]
+	if !ok {
+		return "", ErrUnsigned
+	}
+
+	var block signatureBlock
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&block); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+	}
+	if block.Alg != signatureAlgorithm {
+		return "", fmt.Errorf("%w: unsupported algorithm %q", ErrSignatureInvalid, block.Alg)
+	}
+	pub, err := hex.DecodeString(block.Key)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("%w: malformed key", ErrSignatureInvalid)
+	}
+	sig, err := base64.StdEncoding.DecodeString(block.Sig)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return "", fmt.Errorf("%w: malformed signature", ErrSignatureInvalid)
+	}
+
+	message, err := signedMessage(data)
+	if err != nil {
+		return "", err
+	}
+	if !ed25519.Verify(pub, message, sig) {
+		return "", ErrSignatureInvalid
+	}
+	if len(trusted) == 0 {
+		return block.Key, nil
+	}
+	for _, key := range trusted {
+		if key.Equal(ed25519.PublicKey(pub)) {
+			return block.Key, nil
+		}
+	}
+	return "", fmt.Errorf("%w %s", ErrUntrustedSigner, block.Key)
+}
+
+// signedMessage returns the message a signature over data is made over.
+func signedMessage(data []byte) ([]byte, error) {
+	canonical, err := ejsonjson.Canonicalize(data, ejsonjson.SignatureField)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(signatureContext), canonical...), nil
+}
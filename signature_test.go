@@ -0,0 +1,118 @@
+package ejson
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/ejson/json"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSignatures(t *testing.T) {
+	Convey("Signatures", t, func() {
+		authorPub, author, err := ed25519.GenerateKey(rand.Reader)
+		So(err, ShouldBeNil)
+		otherPub, other, err := ed25519.GenerateKey(rand.Reader)
+		So(err, ShouldBeNil)
+
+		var encrypted bytes.Buffer
+		_, err = Encrypt(strings.NewReader("{\n  \"_public_key\": \""+validPubKey+"\",\n  \"a\": \"b\"\n}\n"), &encrypted)
+		So(err, ShouldBeNil)
+
+		var signed bytes.Buffer
+		_, err = Sign(bytes.NewReader(encrypted.Bytes()), &signed, author)
+		So(err, ShouldBeNil)
+
+		Convey("should be added to the end of the document", func() {
+			So(signed.String(), ShouldStartWith, encrypted.String()[:strings.LastIndex(encrypted.String(), "\n}")])
+			So(signed.String(), ShouldContainSubstring, ",\n  \""+json.SignatureField+"\": {\"alg\":\"ed25519\",\"key\":\"")
+			So(Check(bytes.NewReader(signed.Bytes())), ShouldBeNil)
+		})
+
+		Convey("should verify", func() {
+			signer, err := VerifySignature(bytes.NewReader(signed.Bytes()), nil)
+			So(err, ShouldBeNil)
+			So(signer, ShouldEqual, hex.EncodeToString(authorPub))
+			_, err = VerifySignature(bytes.NewReader(signed.Bytes()), []ed25519.PublicKey{otherPub, authorPub})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("should be replaced when re-signing", func() {
+			var resigned bytes.Buffer
+			_, err = Sign(bytes.NewReader(signed.Bytes()), &resigned, other)
+			So(err, ShouldBeNil)
+			So(strings.Count(resigned.String(), json.SignatureField), ShouldEqual, 1)
+			_, err = VerifySignature(bytes.NewReader(resigned.Bytes()), []ed25519.PublicKey{otherPub})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("should be unaffected by reformatting", func() {
+			reformatted := strings.ReplaceAll(signed.String(), "\n  ", "")
+			_, err := VerifySignature(strings.NewReader(reformatted), []ed25519.PublicKey{authorPub})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("should detect swapped ciphertexts", func() {
+			var again bytes.Buffer
+			_, err = Encrypt(strings.NewReader(`{"_public_key": "`+validPubKey+`", "a": "c"}`), &again)
+			So(err, ShouldBeNil)
+			ciphertext := func(doc string) string {
+				return doc[strings.Index(doc, "EJ[") : strings.Index(doc, "]")+1]
+			}
+			tampered := strings.Replace(signed.String(), ciphertext(signed.String()), ciphertext(again.String()), 1)
+			_, err := VerifySignature(strings.NewReader(tampered), nil)
+			So(errors.Is(err, ErrSignatureInvalid), ShouldBeTrue)
+		})
+
+		Convey("should reject untrusted signers", func() {
+			_, err := VerifySignature(bytes.NewReader(signed.Bytes()), []ed25519.PublicKey{otherPub})
+			So(errors.Is(err, ErrUntrustedSigner), ShouldBeTrue)
+		})
+
+		Convey("should report unsigned documents", func() {
+			_, err := VerifySignature(bytes.NewReader(encrypted.Bytes()), nil)
+			So(errors.Is(err, ErrUnsigned), ShouldBeTrue)
+		})
+
+		Convey("should refuse to sign plaintext", func() {
+			_, err := Sign(strings.NewReader(`{"_public_key": "`+validPubKey+`", "a": "b"}`), &bytes.Buffer{}, author)
+			So(errors.Is(err, ErrNotEncrypted), ShouldBeTrue)
+		})
+
+		Convey("should be checked on decryption if signers are trusted", func() {
+			opts := DecryptOptions{TrustedSigners: []ed25519.PublicKey{authorPub}}
+			var decrypted bytes.Buffer
+			So(DecryptWithOptions(bytes.NewReader(signed.Bytes()), &decrypted, "", validPrivKey, opts), ShouldBeNil)
+			So(decrypted.String(), ShouldContainSubstring, `"a": "b"`)
+			So(decrypted.String(), ShouldContainSubstring, json.SignatureField)
+
+			err := DecryptWithOptions(bytes.NewReader(encrypted.Bytes()), &decrypted, "", validPrivKey, opts)
+			So(errors.Is(err, ErrUnsigned), ShouldBeTrue)
+			opts.TrustedSigners = []ed25519.PublicKey{otherPub}
+			err = DecryptWithOptions(bytes.NewReader(signed.Bytes()), &decrypted, "", validPrivKey, opts)
+			So(errors.Is(err, ErrUntrustedSigner), ShouldBeTrue)
+		})
+	})
+
+	Convey("LoadTrustedKeys", t, func() {
+		dir := t.TempDir()
+		pub, _, err := GenerateSigningKeypair()
+		So(err, ShouldBeNil)
+
+		_, err = LoadTrustedKeys(dir)
+		So(err, ShouldNotBeNil)
+
+		So(os.WriteFile(filepath.Join(dir, "alice"), []byte("# Alice\n"+pub+"\n"), 0o644), ShouldBeNil)
+		So(os.WriteFile(filepath.Join(dir, ".keep"), nil, 0o644), ShouldBeNil)
+		keys, err := LoadTrustedKeys(dir)
+		So(err, ShouldBeNil)
+		So(len(keys), ShouldEqual, 1)
+	})
+}